@@ -2,15 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"github.com/S0me0neR0man/yayaops/internal/client"
-	"time"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 // main
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	c := client.New().Start(ctx)
-	time.Sleep(40 * time.Minute)
-	cancel()
+	cfg, err := client.NewConfig(os.Args[0], os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatal(err)
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	c.Start(ctx)
+	<-ctx.Done()
+	log.Println("shutdown")
 	c.WaitShutdown()
 }
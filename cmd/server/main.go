@@ -1,13 +1,52 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"github.com/S0me0neR0man/yayaops/internal/server"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout the time to finish in-flight requests
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	err := server.New().Start()
+	cfg, err := server.NewConfig(os.Args[0], os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatal(err)
+	}
+	s, err := server.New(cfg)
 	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start()
+	}()
+	select {
+	case err = <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Println(err)
+		}
+	case <-ctx.Done():
+		log.Println("shutdown")
+	}
+
+	ctxShutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = s.Shutdown(ctxShutdown); err != nil {
 		log.Println(err)
 	}
 }
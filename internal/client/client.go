@@ -1,167 +1,386 @@
 package client
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/S0me0neR0man/yayaops/internal/envelope"
+	pb "github.com/S0me0neR0man/yayaops/internal/proto"
 	"github.com/go-resty/resty/v2"
+	"google.golang.org/grpc"
 	"log"
-	"math/rand"
-	"os"
-	"reflect"
-	"runtime"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type config struct {
-	addr           string
-	pollInterval   time.Duration
-	reportInterval time.Duration
-}
+// sendTimeout the timeout of the report request
+const sendTimeout = 10 * time.Second
 
-var cfg config
+// batchIDHeader the id of the report, the server skips the report applied already,
+// so retries and replays of the outbox do not add counter deltas twice
+const batchIDHeader = "X-Batch-ID"
 
-func init() {
-	if cfg.addr = os.Getenv("ADDRESS"); cfg.addr == "" {
-		cfg.addr = "127.0.0.1:8080"
+type metricsEngine struct {
+	cfg        Config
+	storage    *common.Storage
+	client     *resty.Client
+	conn       *grpc.ClientConn // nil if reports are sent by HTTP
+	rpc        pb.MetricsClient // nil if reports are sent by HTTP
+	publicKey  *rsa.PublicKey   // nil if reports are not encrypted
+	realIP     string           // X-Real-IP of reports, empty if unknown
+	batchRun   string           // the random prefix of batch ids of the agent run
+	batchSeq   uint64
+	collectors []Collector
+	outbox     *outbox      // nil if disabled
+	limiter    *rateLimiter // every request of reports, retries and outbox replays waits for it
+	deltas     *deltaTracker
+	wg         sync.WaitGroup
+}
+
+// New the constructor, runtime, custom and host collectors are registered
+func New(cfg Config) (*metricsEngine, error) {
+	log.Printf("Client config %v", cfg)
+	e := metricsEngine{cfg: cfg}
+	e.storage = common.NewStorage()
+	e.client = resty.New().SetTimeout(sendTimeout)
+	run := make([]byte, 8)
+	if _, err := rand.Read(run); err != nil {
+		return nil, err
 	}
-	cfg.pollInterval = 2 * time.Second
-	if s := os.Getenv("POOL_INTERVAL"); s != "" {
-		if v, err := strconv.Atoi(s); err == nil {
-			cfg.pollInterval = time.Duration(v) * time.Second
+	e.batchRun = hex.EncodeToString(run)
+	if ip, err := outboundIP(cfg.Addr); err == nil {
+		e.realIP = ip
+	} else {
+		log.Println("X-Real-IP is unknown:", err)
+	}
+	if cfg.CryptoKey != "" {
+		key, err := envelope.LoadPublicKey(cfg.CryptoKey)
+		if err != nil {
+			return nil, err
 		}
+		e.publicKey = key
 	}
-	cfg.reportInterval = 10 * time.Second
-	if s := os.Getenv("REPORT_INTERVAL"); s != "" {
-		if v, err := strconv.Atoi(s); err == nil {
-			cfg.reportInterval = time.Duration(v) * time.Second
+	var tlsConfig *tls.Config
+	if cfg.tls() {
+		var err error
+		if tlsConfig, err = common.ClientTLSConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey); err != nil {
+			return nil, err
 		}
+		e.client.SetTLSClientConfig(tlsConfig)
 	}
-
-	log.Printf("Client init %+v", cfg)
-}
-
-type metricsEngine struct {
-	storage   *common.Storage
-	pollCount int64
-	wg        sync.WaitGroup
+	if cfg.Transport == TransportGRPC {
+		if err := e.dialGRPC(tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	e.deltas = newDeltaTracker()
+	e.Register(&runtimeCollector{})
+	e.Register(&randomCollector{})
+	e.Register(&pollCountCollector{})
+	if h, err := newHostCollector(); err == nil {
+		e.Register(h)
+	} else {
+		log.Println("host metrics disabled:", err)
+	}
+	if cfg.OutboxFile != "" {
+		o, err := newOutbox(cfg.OutboxFile, int64(cfg.OutboxMaxSize))
+		if err != nil {
+			log.Println("outbox disabled:", err)
+		} else {
+			e.outbox = o
+		}
+	}
+	return &e, nil
 }
 
-func New() *metricsEngine {
-	e := metricsEngine{}
-	e.storage = common.NewStorage()
-	return &e
+// Register add the collector, must be called before Start
+func (m *metricsEngine) Register(c Collector) *metricsEngine {
+	m.collectors = append(m.collectors, c)
+	return m
 }
 
-// Start engine
+// Start engine, goroutines work until ctx is done
+// every collector is polled in its own goroutine, reports are sent by cfg.SendWorkers goroutines
 func (m *metricsEngine) Start(ctx context.Context) *metricsEngine {
-	m.wg.Add(1)
-	go m.pollJob(ctx)
+	for _, c := range m.collectors {
+		m.wg.Add(1)
+		go m.pollJob(ctx, c)
+	}
+	jobs := make(chan []common.Metrics, m.cfg.SendWorkers)
+	m.limiter = newRateLimiter(m.cfg.RateLimit)
+	var senders sync.WaitGroup
+	for i := 0; i < m.cfg.SendWorkers; i++ {
+		senders.Add(1)
+		go m.sendJob(ctx, jobs, &senders)
+	}
+	m.wg.Add(2)
+	go m.reportJob(ctx, jobs)
+	go func() {
+		senders.Wait()
+		m.limiter.stop()
+		m.wg.Done()
+	}()
 	return m
 }
 
-// WaitShutdown wait for a stop goroutines
+// WaitShutdown wait for a stop goroutines and close the connection
 func (m *metricsEngine) WaitShutdown() {
 	m.wg.Wait()
+	if m.conn != nil {
+		if err := m.conn.Close(); err != nil {
+			log.Println(err)
+		}
+	}
 }
 
-// pollJob goroutine for collect metrics
-func (m *metricsEngine) pollJob(ctx context.Context) {
-	// get metrics
-	m.pollMetrics()
-	ticker := time.NewTicker(cfg.pollInterval)
-	// start reportJob goroutine
-	ctxReport, cancelReport := context.WithCancel(ctx)
-	m.wg.Add(1)
-	go m.reportJob(ctxReport)
+// pollJob goroutine for collect metrics of the collector
+func (m *metricsEngine) pollJob(ctx context.Context, c Collector) {
+	defer m.wg.Done()
+	m.pollMetrics(c)
+	ticker := time.NewTicker(m.cfg.PollInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			m.pollMetrics()
+			m.pollMetrics(c)
 		case <-ctx.Done():
-			ticker.Stop()
-			cancelReport()
-			m.wg.Done()
 			return
 		}
 	}
 }
 
-// reportJob goroutine for send report
-func (m *metricsEngine) reportJob(ctx context.Context) {
-	ticker := time.NewTicker(cfg.reportInterval)
+// reportJob goroutine for prepare reports, the report is skipped if all senders are busy
+// the last report is queued on the stop and jobs is closed
+func (m *metricsEngine) reportJob(ctx context.Context, jobs chan<- []common.Metrics) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.cfg.ReportInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			m.sendReport()
+			list := m.buildReport()
+			if len(list) == 0 {
+				continue
+			}
+			select {
+			case jobs <- list:
+			default:
+				// deltas of counters will be sent in the next report
+				m.deltas.release(list)
+				log.Println("report skipped, senders are busy")
+			}
 		case <-ctx.Done():
-			ticker.Stop()
-			m.wg.Done()
+			if list := m.buildReport(); len(list) != 0 {
+				jobs <- list
+			}
+			close(jobs)
 			return
 		}
 	}
 }
 
-func (m *metricsEngine) pollMetrics() {
-	// runtime metrics
-	var ms runtime.MemStats
-	runtime.ReadMemStats(&ms)
-	for _, name := range common.RuntimeMNames {
-		v := reflect.ValueOf(ms).FieldByName(name)
-		switch v.Kind() {
-		case reflect.Int64:
-			_ = m.storage.Set(name, v.Int())
-		case reflect.Uint64, reflect.Uint32:
-			_ = m.storage.Set(name, int64(v.Uint()))
-		case reflect.Float64:
-			_ = m.storage.Set(name, v.Float())
-		default:
-			log.Println("pollMetrics", v.Kind())
+// sendJob goroutine for send reports from jobs until it is closed
+func (m *metricsEngine) sendJob(ctx context.Context, jobs <-chan []common.Metrics, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for list := range jobs {
+		if ctx.Err() == nil {
+			m.deliver(ctx, list)
+			continue
 		}
+		// the last reports on the stop are sent with the own timeout
+		ctxLast, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		m.deliver(ctxLast, list)
+		cancel()
 	}
-	// custom
-	_ = m.storage.Set("RandomValue", rand.Float64())
-	_ = m.storage.Set("PollCount", m.pollCount)
-	m.pollCount++
 }
 
-func (m *metricsEngine) sendReport() {
-	c := resty.New()
-	for _, name := range m.storage.GetNames() {
-		if val, ok := m.storage.Get(name); ok {
-			m := common.Metrics{}
-			//m.MType = typeOfMetric(val)
-			if name == "PollCount" {
-				m.MType = common.MTypeCounter
+// deliver send reports of the outbox then the report,
+// the report is spooled to the outbox if the server is unavailable
+// counters of the delivered or spooled report are acknowledged, otherwise they are sent again
+// the report keeps its batch id in retries and in the outbox, the server skips the applied one
+func (m *metricsEngine) deliver(ctx context.Context, list []common.Metrics) {
+	batch := m.nextBatchID()
+	if m.outbox != nil {
+		err := m.outbox.replay(func(batch string, l []common.Metrics) error {
+			return m.send(ctx, batch, l)
+		})
+		if err != nil {
+			log.Println("outbox replay:", err)
+			m.spool(batch, list)
+			return
+		}
+	}
+	err := m.send(ctx, batch, list)
+	if err == nil {
+		m.deltas.ack(list)
+		return
+	}
+	log.Println("report is not delivered:", err)
+	if isRetriable(err) {
+		m.spool(batch, list)
+	} else {
+		m.deltas.release(list)
+	}
+}
+
+// nextBatchID the id of the new report, unique for the agent run
+func (m *metricsEngine) nextBatchID() string {
+	return fmt.Sprintf("%s-%d", m.batchRun, atomic.AddUint64(&m.batchSeq, 1))
+}
+
+// spool the report to the outbox, the outbox delivers counters later
+func (m *metricsEngine) spool(batch string, list []common.Metrics) {
+	if m.outbox == nil {
+		m.deltas.release(list)
+		return
+	}
+	if err := m.outbox.push(batch, list); err != nil {
+		log.Println("outbox:", err)
+		m.deltas.release(list)
+		return
+	}
+	m.deltas.ack(list)
+}
+
+// pollMetrics save values of the collector to the storage
+func (m *metricsEngine) pollMetrics(c Collector) {
+	values, err := c.Collect()
+	if err != nil {
+		log.Printf("collector %s: %v", c.Name(), err)
+		return
+	}
+	mType := c.MType()
+	now := time.Now()
+	for id, val := range values {
+		switch v := val.(type) {
+		case float64:
+			if mType != common.MTypeGauge {
+				log.Printf("collector %s: %s counter must be int64", c.Name(), id)
+				continue
+			}
+			m.storage.SetGauge(common.Key{ID: id}, v, now)
+		case int64:
+			if mType == common.MTypeGauge {
+				m.storage.SetGauge(common.Key{ID: id}, float64(v), now)
 			} else {
-				m.MType = common.MTypeGauge
+				m.storage.SetCounter(common.Key{ID: id}, v, now)
 			}
-			m.ID = name
-			if err := m.SetAnyValue(val); err != nil {
-				log.Println(err)
+		default:
+			log.Printf("collector %s: %s wrong value type %T", c.Name(), id, val)
+		}
+	}
+}
+
+// buildReport the list of all metrics from the storage
+// counters contain deltas since the last acknowledged report, zero deltas are skipped,
+// the report must be passed to deltas.ack or deltas.release
+func (m *metricsEngine) buildReport() []common.Metrics {
+	all := m.storage.List()
+	list := make([]common.Metrics, 0, len(all))
+	for _, metric := range all {
+		if metric.MType == common.MTypeCounter {
+			delta := m.deltas.take(metric.ID, *metric.Delta)
+			if delta == 0 {
+				continue
 			}
-			b, _ := json.Marshal(m)
-			log.Println(string(b))
-			url := fmt.Sprintf("http://%s/update/", cfg.addr)
-			resp, err := c.R().SetHeader("Content-Type", "application/json").SetBody(b).Post(url)
-			if err != nil {
-				log.Println(resp, err)
+			*metric.Delta = delta
+		}
+		metric.Source = m.cfg.Source
+		metric.Updated = nil // the time of the update is set by the server
+		if m.cfg.Key != "" {
+			if err := metric.SetHash(m.cfg.Key); err != nil {
+				log.Println(err)
+				m.deltas.release([]common.Metrics{metric})
+				continue
 			}
 		}
+		list = append(list, metric)
+	}
+	return list
+}
+
+// send the report by the transport of the config, batch is the id of the report, may be empty
+// retriable errors are retried until ctx is done, every attempt waits for the rate limiter
+func (m *metricsEngine) send(ctx context.Context, batch string, list []common.Metrics) error {
+	if m.rpc != nil {
+		return m.sendGRPC(ctx, batch, list)
 	}
+	return m.sendHTTP(ctx, batch, list)
 }
 
-func typeOfMetric(val any) string {
-	switch v := reflect.ValueOf(val); v.Kind() {
-	case reflect.Float64:
-		return common.MTypeGauge
-	case reflect.Int64:
-		return common.MTypeCounter
-	default:
-		log.Fatal("client.typeOfMetric() unknown metric type:", v.Kind().String())
+// sendHTTP the report to POST /updates/, the compressed body is encrypted if the public key is set
+func (m *metricsEngine) sendHTTP(ctx context.Context, batch string, list []common.Metrics) error {
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d metrics by HTTP", len(list))
+	body, err := compress(b)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Content-Type":     "application/json",
+		"Content-Encoding": "gzip",
+	}
+	if m.realIP != "" {
+		headers[realIPHeader] = m.realIP
+	}
+	if batch != "" {
+		headers[batchIDHeader] = batch
+	}
+	if m.publicKey != nil {
+		if body, err = envelope.Seal(m.publicKey, body); err != nil {
+			return err
+		}
+		headers[envelope.Header] = envelope.Scheme
+	}
+	url := m.url("/updates/")
+	return withRetry(ctx, func(ctx context.Context) error {
+		if err := m.limiter.wait(ctx); err != nil {
+			return err
+		}
+		resp, err := m.client.R().
+			SetContext(ctx).
+			SetHeaders(headers).
+			SetBody(body).
+			Post(url)
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			return &statusError{code: resp.StatusCode(), body: resp.String()}
+		}
+		return nil
+	})
+}
+
+// url of the path on the server, https is used if TLS is configured
+func (m *metricsEngine) url(path string) string {
+	scheme := "http"
+	if m.cfg.tls() {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, m.cfg.Addr, path)
+}
+
+// compress gzip the request body
+func compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
 	}
-	return "unknown"
+	return buf.Bytes(), nil
 }
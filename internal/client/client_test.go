@@ -1,8 +1,208 @@
 package client
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/S0me0neR0man/yayaops/internal/envelope"
+	"github.com/go-resty/resty/v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_metricType(t *testing.T) {
 }
+
+func TestNewConfig_HostnameSource(t *testing.T) {
+	cfg, err := NewConfig("agent", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	if cfg.Source != hostname {
+		t.Errorf("NewConfig() source = %q, want the hostname %q", cfg.Source, hostname)
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	t.Setenv("POLL_INTERVAL", "1s")
+	t.Setenv("RATE_LIMIT", "5")
+	t.Setenv("SOURCE", "host-1")
+	cfg, err := NewConfig("agent", []string{"-a", ":9090", "-p", "5s", "-r", "20", "-w", "3", "-l", "1", "-s", "host-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Config{Addr: ":9090", PollInterval: time.Second, ReportInterval: 20 * time.Second, SendWorkers: 3, RateLimit: 5, OutboxMaxSize: 10 << 20, Source: "host-1", Transport: TransportHTTP}
+	if cfg != want {
+		t.Errorf("NewConfig() = %v, want %v", cfg, want)
+	}
+
+	if _, err = NewConfig("agent", []string{"-r", "0s"}); err == nil {
+		t.Errorf("NewConfig() with zero report interval must fail")
+	}
+	if _, err = NewConfig("agent", []string{"-w", "0"}); err == nil {
+		t.Errorf("NewConfig() without send workers must fail")
+	}
+	if _, err = NewConfig("agent", []string{"-t", "udp"}); err == nil {
+		t.Errorf("NewConfig() with unknown transport must fail")
+	}
+	if _, err = NewConfig("agent", []string{"-t", "grpc", "-c", "public.pem"}); err == nil {
+		t.Errorf("NewConfig() with encryption of gRPC must fail")
+	}
+	if _, err = NewConfig("agent", []string{"-tls-key", "key.pem"}); err == nil {
+		t.Errorf("NewConfig() with the TLS key without the certificate must fail")
+	}
+}
+
+func TestMetricsEngine_url(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{name: "http", cfg: Config{Addr: "localhost:8080"}, want: "http://localhost:8080/updates/"},
+		{name: "tls", cfg: Config{Addr: "localhost:8080", TLSCA: "ca.pem"}, want: "https://localhost:8080/updates/"},
+		{name: "mtls", cfg: Config{Addr: "localhost:8080", TLSCert: "cert.pem", TLSKey: "key.pem"}, want: "https://localhost:8080/updates/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &metricsEngine{cfg: tt.cfg}
+			if got := m.url("/updates/"); got != tt.want {
+				t.Errorf("url() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsEngine_Start(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer srv.Close()
+
+	cfg := Config{
+		Addr:           strings.TrimPrefix(srv.URL, "http://"),
+		PollInterval:   10 * time.Millisecond,
+		ReportInterval: 20 * time.Millisecond,
+		SendWorkers:    2,
+		RateLimit:      100,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	e, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Start(ctx).WaitShutdown()
+
+	// reports by the ticker and the last one on the stop
+	if n := atomic.LoadInt32(&requests); n < 2 {
+		t.Errorf("requests = %d, want at least 2", n)
+	}
+}
+
+func Test_rateLimiter(t *testing.T) {
+	l := newRateLimiter(100)
+	defer l.stop()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("3 events at 100/s took %v, want at least 20ms", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := newRateLimiter(1).wait(ctx); err == nil {
+		t.Errorf("wait() with done context must fail")
+	}
+}
+
+func TestMetricsEngine_deliverRateLimit(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer srv.Close()
+
+	o, err := newOutbox(filepath.Join(t.TempDir(), "outbox.jsonl"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"A", "B", "C"} {
+		if err = o.push("batch-"+id, outboxReport(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := &metricsEngine{
+		cfg:     Config{Addr: strings.TrimPrefix(srv.URL, "http://")},
+		client:  resty.New(),
+		outbox:  o,
+		deltas:  newDeltaTracker(),
+		limiter: newRateLimiter(50),
+	}
+	defer m.limiter.stop()
+
+	// 3 replayed records and the report wait for the limiter
+	start := time.Now()
+	m.deliver(context.Background(), []common.Metrics{common.NewGauge("Alloc", 1)})
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("requests = %d, want 4", n)
+	}
+	if d := time.Since(start); d < 60*time.Millisecond {
+		t.Errorf("4 requests at 50/s took %v, want at least 60ms", d)
+	}
+}
+
+func TestMetricsEngine_sendEncrypted(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []common.Metrics
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := r.Header.Get(envelope.Header); s != envelope.Scheme {
+			t.Errorf("%s = %q, want %q", envelope.Header, s, envelope.Scheme)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			b, err = envelope.Open(priv, b)
+		}
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err = json.NewDecoder(gz).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	m := &metricsEngine{cfg: Config{Addr: strings.TrimPrefix(srv.URL, "http://")}, client: resty.New(), publicKey: &priv.PublicKey}
+	if err = m.send(context.Background(), "", []common.Metrics{common.NewGauge("Alloc", 2.5)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "Alloc" || got[0].StrValue() != "2.5" {
+		t.Errorf("send() sent %+v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"math/rand"
+	"runtime"
+)
+
+// Collector the source of metrics polled by metricsEngine
+type Collector interface {
+	// Name of the collector for logs
+	Name() string
+	// MType the type of all collected metrics, common.MTypeGauge or common.MTypeCounter
+	MType() string
+	// Collect returns values by metric ID, float64 for gauges, int64 for counters
+	// counters are totals since the start, the agent sends deltas to the server
+	Collect() (map[string]any, error)
+}
+
+// funcCollector the Collector calling the function
+type funcCollector struct {
+	name    string
+	mType   string
+	collect func() (map[string]any, error)
+}
+
+// NewCollector the Collector of the application metrics calculated by the collect function
+func NewCollector(name, mType string, collect func() (map[string]any, error)) Collector {
+	return &funcCollector{name: name, mType: mType, collect: collect}
+}
+
+func (c *funcCollector) Name() string {
+	return c.name
+}
+
+func (c *funcCollector) MType() string {
+	return c.mType
+}
+
+func (c *funcCollector) Collect() (map[string]any, error) {
+	return c.collect()
+}
+
+// runtimeCollector gauges from runtime.MemStats without reflection,
+// names must match common.RuntimeMNames, Test_runtimeCollector checks it
+type runtimeCollector struct{}
+
+func (c *runtimeCollector) Name() string {
+	return "runtime"
+}
+
+func (c *runtimeCollector) MType() string {
+	return common.MTypeGauge
+}
+
+func (c *runtimeCollector) Collect() (map[string]any, error) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return map[string]any{
+		"Alloc":         float64(ms.Alloc),
+		"BuckHashSys":   float64(ms.BuckHashSys),
+		"Frees":         float64(ms.Frees),
+		"GCCPUFraction": ms.GCCPUFraction,
+		"GCSys":         float64(ms.GCSys),
+		"HeapAlloc":     float64(ms.HeapAlloc),
+		"HeapIdle":      float64(ms.HeapIdle),
+		"HeapInuse":     float64(ms.HeapInuse),
+		"HeapObjects":   float64(ms.HeapObjects),
+		"HeapReleased":  float64(ms.HeapReleased),
+		"HeapSys":       float64(ms.HeapSys),
+		"LastGC":        float64(ms.LastGC),
+		"Lookups":       float64(ms.Lookups),
+		"MCacheInuse":   float64(ms.MCacheInuse),
+		"MCacheSys":     float64(ms.MCacheSys),
+		"MSpanInuse":    float64(ms.MSpanInuse),
+		"MSpanSys":      float64(ms.MSpanSys),
+		"Mallocs":       float64(ms.Mallocs),
+		"NextGC":        float64(ms.NextGC),
+		"NumForcedGC":   float64(ms.NumForcedGC),
+		"NumGC":         float64(ms.NumGC),
+		"OtherSys":      float64(ms.OtherSys),
+		"PauseTotalNs":  float64(ms.PauseTotalNs),
+		"StackInuse":    float64(ms.StackInuse),
+		"StackSys":      float64(ms.StackSys),
+		"Sys":           float64(ms.Sys),
+		"TotalAlloc":    float64(ms.TotalAlloc),
+	}, nil
+}
+
+// randomCollector the RandomValue gauge
+type randomCollector struct{}
+
+func (c *randomCollector) Name() string {
+	return "random"
+}
+
+func (c *randomCollector) MType() string {
+	return common.MTypeGauge
+}
+
+func (c *randomCollector) Collect() (map[string]any, error) {
+	return map[string]any{"RandomValue": rand.Float64()}, nil
+}
+
+// pollCountCollector the PollCount counter, the number of polls
+type pollCountCollector struct {
+	pollCount int64
+}
+
+func (c *pollCountCollector) Name() string {
+	return "pollcount"
+}
+
+func (c *pollCountCollector) MType() string {
+	return common.MTypeCounter
+}
+
+func (c *pollCountCollector) Collect() (map[string]any, error) {
+	values := map[string]any{"PollCount": c.pollCount}
+	c.pollCount++
+	return values, nil
+}
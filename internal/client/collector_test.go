@@ -0,0 +1,78 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEngine_Register(t *testing.T) {
+	var got []common.Metrics
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err = json.NewDecoder(gz).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	m := &metricsEngine{
+		cfg:     Config{Addr: strings.TrimPrefix(srv.URL, "http://"), Source: "host-1"},
+		storage: common.NewStorage(),
+		client:  resty.New(),
+		deltas:  newDeltaTracker(),
+	}
+	m.Register(NewCollector("app", common.MTypeCounter, func() (map[string]any, error) {
+		return map[string]any{"Requests": int64(5), "Wrong": 1.5}, nil
+	}))
+	m.Register(NewCollector("queue", common.MTypeGauge, func() (map[string]any, error) {
+		return map[string]any{"QueueLen": int64(3)}, nil
+	}))
+	for _, c := range m.collectors {
+		m.pollMetrics(c)
+	}
+	if err := m.send(context.Background(), "", m.buildReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"Requests": common.MTypeCounter + ":5",
+		"QueueLen": common.MTypeGauge + ":3",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("send() sent %+v, want %v", got, want)
+	}
+	for _, metric := range got {
+		if s := metric.MType + ":" + metric.StrValue(); want[metric.ID] != s {
+			t.Errorf("send() %s = %s, want %s", metric.ID, s, want[metric.ID])
+		}
+		if metric.Source != "host-1" {
+			t.Errorf("send() %s source = %q, want host-1", metric.ID, metric.Source)
+		}
+	}
+}
+
+func Test_runtimeCollector(t *testing.T) {
+	values, err := (&runtimeCollector{}).Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != len(common.RuntimeMNames) {
+		t.Errorf("Collect() len = %d, want %d", len(values), len(common.RuntimeMNames))
+	}
+	for _, name := range common.RuntimeMNames {
+		if _, ok := values[name].(float64); !ok {
+			t.Errorf("Collect() %s = %v, want float64", name, values[name])
+		}
+	}
+}
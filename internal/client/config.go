@@ -0,0 +1,140 @@
+package client
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"os"
+	"time"
+)
+
+// transports of reports
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+// Config the agent configuration
+type Config struct {
+	Addr           string        // ADDRESS, -a
+	PollInterval   time.Duration // POLL_INTERVAL, -p
+	ReportInterval time.Duration // REPORT_INTERVAL, -r
+	Key            string        // KEY, -k
+	SendWorkers    int           // SEND_WORKERS, -w, the number of concurrent report senders
+	RateLimit      int           // RATE_LIMIT, -l, requests per second, 0 is unlimited
+	OutboxFile     string        // OUTBOX_FILE, -o, the file of undelivered reports, empty disables the outbox
+	OutboxMaxSize  int           // OUTBOX_MAX_SIZE, -m, the outbox file size limit in bytes
+	Source         string        // SOURCE, -s, the agent identity sent with metrics, the hostname by default
+	Transport      string        // TRANSPORT, -t, http or grpc, Addr is the address of the gRPC service for grpc
+	CryptoKey      string        // CRYPTO_KEY, -c, the PEM file of the server RSA public key, empty disables encryption
+
+	// the server is connected by TLS if the CA or the certificate is set
+	TLSCA   string // TLS_CA, -tls-ca, the PEM file of CA certificates to verify the server, system roots if empty
+	TLSCert string // TLS_CERT, -tls-cert, the PEM file of the client certificate
+	TLSKey  string // TLS_KEY, -tls-key, the PEM file of the key of the client certificate
+}
+
+// NewConfig parse command line arguments without the program name,
+// environment variables take precedence over flags
+func NewConfig(name string, args []string) (Config, error) {
+	cfg := Config{}
+	cfg.PollInterval = 2 * time.Second
+	cfg.ReportInterval = 10 * time.Second
+	cfg.SendWorkers = 1
+	cfg.OutboxMaxSize = 10 << 20
+	cfg.Source, _ = os.Hostname()
+	cfg.Transport = TransportHTTP
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.Addr, "a", "127.0.0.1:8080", "server address `host:port`")
+	fs.Var(common.DurationValue{D: &cfg.PollInterval}, "p", "poll `interval` like 2s")
+	fs.Var(common.DurationValue{D: &cfg.ReportInterval}, "r", "report `interval` like 10s")
+	fs.StringVar(&cfg.Key, "k", "", "the `key` of the metrics hash")
+	fs.IntVar(&cfg.SendWorkers, "w", cfg.SendWorkers, "the `number` of concurrent report senders")
+	fs.IntVar(&cfg.RateLimit, "l", 0, "outgoing `requests` per second, 0 is unlimited")
+	fs.StringVar(&cfg.OutboxFile, "o", "", "the outbox `file` of undelivered reports, empty disables the outbox")
+	fs.IntVar(&cfg.OutboxMaxSize, "m", cfg.OutboxMaxSize, "the outbox file size limit in `bytes`")
+	fs.StringVar(&cfg.Source, "s", cfg.Source, "the agent `identity` sent with metrics")
+	fs.StringVar(&cfg.Transport, "t", cfg.Transport, "the `transport` of reports, http or grpc")
+	fs.StringVar(&cfg.CryptoKey, "c", "", "the PEM `file` of the server RSA public key to encrypt reports")
+	fs.StringVar(&cfg.TLSCA, "tls-ca", "", "the PEM `file` of CA certificates to verify the server, enables TLS")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "the PEM `file` of the client certificate, enables TLS")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "the PEM `file` of the key of the client certificate")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	common.EnvString("ADDRESS", &cfg.Addr)
+	common.EnvString("KEY", &cfg.Key)
+	common.EnvString("OUTBOX_FILE", &cfg.OutboxFile)
+	common.EnvString("SOURCE", &cfg.Source)
+	common.EnvString("TRANSPORT", &cfg.Transport)
+	common.EnvString("CRYPTO_KEY", &cfg.CryptoKey)
+	common.EnvString("TLS_CA", &cfg.TLSCA)
+	common.EnvString("TLS_CERT", &cfg.TLSCert)
+	common.EnvString("TLS_KEY", &cfg.TLSKey)
+	// POOL_INTERVAL is the old name of POLL_INTERVAL
+	if err := common.EnvDuration("POOL_INTERVAL", &cfg.PollInterval); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvDuration("POLL_INTERVAL", &cfg.PollInterval); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvDuration("REPORT_INTERVAL", &cfg.ReportInterval); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvInt("SEND_WORKERS", &cfg.SendWorkers); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvInt("RATE_LIMIT", &cfg.RateLimit); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvInt("OUTBOX_MAX_SIZE", &cfg.OutboxMaxSize); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Addr == "" {
+		return cfg, errors.New("empty address")
+	}
+	if cfg.Transport != TransportHTTP && cfg.Transport != TransportGRPC {
+		return cfg, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+	if cfg.CryptoKey != "" && cfg.Transport != TransportHTTP {
+		return cfg, fmt.Errorf("encryption is supported by the %s transport only", TransportHTTP)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, errors.New("the TLS certificate and the key must be set together")
+	}
+	if cfg.PollInterval <= 0 {
+		return cfg, fmt.Errorf("poll interval must be positive, got %v", cfg.PollInterval)
+	}
+	if cfg.ReportInterval <= 0 {
+		return cfg, fmt.Errorf("report interval must be positive, got %v", cfg.ReportInterval)
+	}
+	if cfg.SendWorkers <= 0 {
+		return cfg, fmt.Errorf("send workers must be positive, got %d", cfg.SendWorkers)
+	}
+	if cfg.RateLimit < 0 {
+		return cfg, fmt.Errorf("negative rate limit %d", cfg.RateLimit)
+	}
+	if cfg.OutboxFile != "" && cfg.OutboxMaxSize <= 0 {
+		return cfg, fmt.Errorf("outbox max size must be positive, got %d", cfg.OutboxMaxSize)
+	}
+	return cfg, nil
+}
+
+// tls true if the server is connected by TLS
+func (c Config) tls() bool {
+	return c.TLSCA != "" || c.TLSCert != ""
+}
+
+// String hides secrets
+func (c Config) String() string {
+	type plain Config
+	p := plain(c)
+	if p.Key != "" {
+		p.Key = "***"
+	}
+	return fmt.Sprintf("%+v", p)
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"sync"
+)
+
+// deltaTracker counts the part of every counter acknowledged by the server
+// reports in flight reserve their deltas, so concurrent reports do not send the same delta twice
+type deltaTracker struct {
+	mu       sync.Mutex
+	acked    map[string]int64 // the total delivered or spooled to the outbox
+	inFlight map[string]int64 // the total of reports being sent
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		acked:    make(map[string]int64),
+		inFlight: make(map[string]int64),
+	}
+}
+
+// take returns the delta of the counter since the last acknowledged report and reserves it
+func (t *deltaTracker) take(id string, total int64) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delta := total - t.acked[id] - t.inFlight[id]
+	t.inFlight[id] += delta
+	return delta
+}
+
+// ack counters of the delivered report
+func (t *deltaTracker) ack(list []common.Metrics) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, m := range list {
+		if m.MType == common.MTypeCounter && m.Delta != nil {
+			t.inFlight[m.ID] -= *m.Delta
+			t.acked[m.ID] += *m.Delta
+		}
+	}
+}
+
+// release counters of the undelivered report, deltas will be sent in the next report
+func (t *deltaTracker) release(list []common.Metrics) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, m := range list {
+		if m.MType == common.MTypeCounter && m.Delta != nil {
+			t.inFlight[m.ID] -= *m.Delta
+		}
+	}
+}
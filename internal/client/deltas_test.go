@@ -0,0 +1,36 @@
+package client
+
+import (
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"testing"
+)
+
+func TestDeltaTracker(t *testing.T) {
+	d := newDeltaTracker()
+	report := func(id string, total int64) []common.Metrics {
+		delta := d.take(id, total)
+		return []common.Metrics{{ID: id, MType: common.MTypeCounter, Delta: &delta}}
+	}
+
+	r1 := report("PollCount", 5)
+	if *r1[0].Delta != 5 {
+		t.Fatalf("first delta = %d, want 5", *r1[0].Delta)
+	}
+	// the concurrent report does not repeat the delta in flight
+	r2 := report("PollCount", 7)
+	if *r2[0].Delta != 2 {
+		t.Fatalf("delta in flight = %d, want 2", *r2[0].Delta)
+	}
+	d.ack(r1)
+	d.release(r2)
+
+	// the failed delta is preserved
+	r3 := report("PollCount", 9)
+	if *r3[0].Delta != 4 {
+		t.Fatalf("delta after failure = %d, want 4", *r3[0].Delta)
+	}
+	d.ack(r3)
+	if delta := d.take("PollCount", 9); delta != 0 {
+		t.Errorf("delta after ack = %d, want 0", delta)
+	}
+}
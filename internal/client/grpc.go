@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	pb "github.com/S0me0neR0man/yayaops/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"log"
+)
+
+// dialGRPC create the connection to the gRPC service at cfg.Addr, TLS is used if tlsConfig is set
+// the connection is established by the first report
+func (m *metricsEngine) dialGRPC(tlsConfig *tls.Config) error {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(m.cfg.Addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+	m.rpc = pb.NewMetricsClient(conn)
+	return nil
+}
+
+// sendGRPC the report by Updates, all metrics are applied or nothing like POST /updates/
+func (m *metricsEngine) sendGRPC(ctx context.Context, batch string, list []common.Metrics) error {
+	req := &pb.UpdatesRequest{Metrics: make([]*pb.Metric, 0, len(list))}
+	for _, metric := range list {
+		req.Metrics = append(req.Metrics, pb.FromMetrics(metric))
+	}
+	log.Printf("%d metrics by gRPC", len(list))
+	if m.realIP != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, realIPHeader, m.realIP)
+	}
+	if batch != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, batchIDHeader, batch)
+	}
+	return withRetry(ctx, func(ctx context.Context) error {
+		if err := m.limiter.wait(ctx); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+		defer cancel()
+		_, err := m.rpc.Updates(ctx, req, grpc.UseCompressor(gzip.Name))
+		return err
+	})
+}
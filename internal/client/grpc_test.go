@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	pb "github.com/S0me0neR0man/yayaops/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetricsServer answers Updates by codes in order, the last code is repeated
+type fakeMetricsServer struct {
+	pb.UnimplementedMetricsServer
+	mu       sync.Mutex
+	codes    []codes.Code
+	requests []*pb.UpdatesRequest
+	realIP   []string
+}
+
+func (f *fakeMetricsServer) Updates(ctx context.Context, req *pb.UpdatesRequest) (*pb.UpdatesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.realIP = md.Get(realIPHeader)
+	code := f.codes[len(f.codes)-1]
+	if len(f.requests) <= len(f.codes) {
+		code = f.codes[len(f.requests)-1]
+	}
+	if code != codes.OK {
+		return nil, status.Error(code, code.String())
+	}
+	return &pb.UpdatesResponse{}, nil
+}
+
+// startFakeMetricsServer the address of the gRPC server
+func startFakeMetricsServer(t *testing.T, f *fakeMetricsServer) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer()
+	pb.RegisterMetricsServer(g, f)
+	go func() {
+		_ = g.Serve(l)
+	}()
+	t.Cleanup(g.Stop)
+	return l.Addr().String()
+}
+
+func TestMetricsEngine_sendGRPC(t *testing.T) {
+	saved := retryDelays
+	retryDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+	defer func() { retryDelays = saved }()
+
+	tests := []struct {
+		name         string
+		codes        []codes.Code
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "success", codes: []codes.Code{codes.OK}, wantAttempts: 1},
+		{name: "unavailable retried", codes: []codes.Code{codes.Unavailable, codes.OK}, wantAttempts: 2},
+		{name: "invalid argument not retried", codes: []codes.Code{codes.InvalidArgument}, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeMetricsServer{codes: tt.codes}
+			m := &metricsEngine{cfg: Config{Addr: startFakeMetricsServer(t, f), Transport: TransportGRPC}, realIP: "127.0.0.1"}
+			if err := m.dialGRPC(nil); err != nil {
+				t.Fatal(err)
+			}
+			defer m.conn.Close()
+
+			err := m.send(context.Background(), "", []common.Metrics{common.NewGauge("Alloc", 2.5), common.NewCounter("PollCount", 3)})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(f.requests) != tt.wantAttempts {
+				t.Fatalf("send() attempts = %d, want %d", len(f.requests), tt.wantAttempts)
+			}
+			got := f.requests[0].GetMetrics()
+			if len(got) != 2 || got[0].GetId() != "Alloc" || got[0].GetValue() != 2.5 || got[1].GetDelta() != 3 {
+				t.Errorf("send() sent %v", got)
+			}
+			if len(f.realIP) != 1 || f.realIP[0] != "127.0.0.1" {
+				t.Errorf("send() %s = %v, want 127.0.0.1", realIPHeader, f.realIP)
+			}
+		})
+	}
+}
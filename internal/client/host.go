@@ -0,0 +1,153 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cpuTimes the idle and total jiffies of one CPU from /proc/stat
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+// hostCollector the host memory and CPU gauges, keeps previous CPU times between polls
+type hostCollector struct {
+	prevCPU []cpuTimes
+}
+
+// newHostCollector returns the error if host metrics are not available
+func newHostCollector() (*hostCollector, error) {
+	h := &hostCollector{}
+	if _, err := h.Collect(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *hostCollector) Name() string {
+	return "host"
+}
+
+func (h *hostCollector) MType() string {
+	return common.MTypeGauge
+}
+
+func (h *hostCollector) Collect() (map[string]any, error) {
+	total, free, err := readMemInfo()
+	if err != nil {
+		return nil, err
+	}
+	cpus, err := readCPUTimes()
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]any{
+		"TotalMemory": float64(total),
+		"FreeMemory":  float64(free),
+	}
+	for i, cur := range cpus {
+		var prev cpuTimes
+		if i < len(h.prevCPU) {
+			prev = h.prevCPU[i]
+		}
+		values[fmt.Sprintf("CPUutilization%d", i+1)] = cpuUtilization(prev, cur)
+	}
+	h.prevCPU = cpus
+	return values, nil
+}
+
+// cpuUtilization percent of the busy time between two samples, in [0, 100]
+// iowait of /proc/stat can go backwards, then the idle time is not changed
+func cpuUtilization(prev, cur cpuTimes) float64 {
+	if cur.total <= prev.total {
+		return 0
+	}
+	total := float64(cur.total - prev.total)
+	var idle float64
+	if cur.idle > prev.idle {
+		idle = float64(cur.idle - prev.idle)
+	}
+	if idle >= total {
+		return 0
+	}
+	return 100 * (total - idle) / total
+}
+
+// parseMemInfo returns MemTotal and MemFree in bytes from /proc/meminfo
+func parseMemInfo(r io.Reader) (total, free uint64, err error) {
+	var foundTotal, foundFree bool
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// MemTotal:       16314464 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		var p *uint64
+		switch fields[0] {
+		case "MemTotal:":
+			p, foundTotal = &total, true
+		case "MemFree:":
+			p, foundFree = &free, true
+		default:
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("meminfo %s: %w", fields[0], err)
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			v *= 1024
+		}
+		*p = v
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if !foundTotal || !foundFree {
+		return 0, 0, fmt.Errorf("meminfo: MemTotal or MemFree not found")
+	}
+	return total, free, nil
+}
+
+// parseCPUTimes returns times of every CPU from /proc/stat, the summary 'cpu' line is skipped
+func parseCPUTimes(r io.Reader) ([]cpuTimes, error) {
+	var cpus []cpuTimes
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// cpu0 user nice system idle iowait irq softirq steal guest guest_nice
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		var t cpuTimes
+		// guest time is already included in user time
+		for i, s := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+			v, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("stat %s: %w", fields[0], err)
+			}
+			t.total += v
+			// idle and iowait
+			if i == 3 || i == 4 {
+				t.idle += v
+			}
+		}
+		cpus = append(cpus, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("stat: cpu lines not found")
+	}
+	return cpus, nil
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package client
+
+import "os"
+
+// readMemInfo returns total and free host memory in bytes
+func readMemInfo() (total, free uint64, err error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	return parseMemInfo(f)
+}
+
+// readCPUTimes returns times of every host CPU
+func readCPUTimes() ([]cpuTimes, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCPUTimes(f)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package client
+
+import "errors"
+
+var errHostUnsupported = errors.New("host metrics are supported on linux only")
+
+// readMemInfo returns total and free host memory in bytes
+func readMemInfo() (total, free uint64, err error) {
+	return 0, 0, errHostUnsupported
+}
+
+// readCPUTimes returns times of every host CPU
+func readCPUTimes() ([]cpuTimes, error) {
+	return nil, errHostUnsupported
+}
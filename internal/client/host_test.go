@@ -0,0 +1,63 @@
+package client
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_parseMemInfo(t *testing.T) {
+	const meminfo = `MemTotal:       16314464 kB
+MemFree:         1021432 kB
+MemAvailable:    9876543 kB
+`
+	total, free, err := parseMemInfo(strings.NewReader(meminfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 16314464*1024 || free != 1021432*1024 {
+		t.Errorf("parseMemInfo() = %d, %d", total, free)
+	}
+	if _, _, err = parseMemInfo(strings.NewReader("MemTotal: 1 kB\n")); err == nil {
+		t.Errorf("parseMemInfo() without MemFree must fail")
+	}
+}
+
+func Test_parseCPUTimes(t *testing.T) {
+	const stat = `cpu  200 0 100 600 100 0 0 0 0 0
+cpu0 100 0 50 300 50 0 0 0 0 0
+cpu1 100 0 50 300 50 0 0 0 0 0
+intr 12345
+`
+	cpus, err := parseCPUTimes(strings.NewReader(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cpus) != 2 {
+		t.Fatalf("parseCPUTimes() len = %d, want 2", len(cpus))
+	}
+	want := cpuTimes{idle: 350, total: 500}
+	if cpus[0] != want {
+		t.Errorf("parseCPUTimes()[0] = %+v, want %+v", cpus[0], want)
+	}
+}
+
+func Test_cpuUtilization(t *testing.T) {
+	tests := []struct {
+		name string
+		prev cpuTimes
+		cur  cpuTimes
+		want float64
+	}{
+		{name: "busy", prev: cpuTimes{idle: 350, total: 500}, cur: cpuTimes{idle: 375, total: 600}, want: 75},
+		{name: "without changes", prev: cpuTimes{idle: 350, total: 500}, cur: cpuTimes{idle: 350, total: 500}, want: 0},
+		{name: "idle goes backwards", prev: cpuTimes{idle: 350, total: 500}, cur: cpuTimes{idle: 340, total: 600}, want: 100},
+		{name: "idle more than total", prev: cpuTimes{idle: 350, total: 500}, cur: cpuTimes{idle: 500, total: 600}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cpuUtilization(tt.prev, tt.cur); got != tt.want {
+				t.Errorf("cpuUtilization() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
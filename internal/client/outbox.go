@@ -0,0 +1,209 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// outboxRecord the undelivered report, one JSON line of the outbox file
+type outboxRecord struct {
+	Seq     uint64           `json:"seq"`
+	Batch   string           `json:"batch,omitempty"` // the id of the report, empty in records of old versions
+	Metrics []common.Metrics `json:"metrics"`
+}
+
+// outbox the append-only file of undelivered reports
+// records are replayed in order when the server is available again,
+// counter deltas of spooled reports are acknowledged, so they are delivered by the outbox only
+type outbox struct {
+	mu       sync.Mutex
+	fileName string
+	maxSize  int64
+	size     int64
+	seq      uint64
+}
+
+// newOutbox open the outbox, records of the previous run are kept
+func newOutbox(fileName string, maxSize int64) (*outbox, error) {
+	o := &outbox{fileName: fileName, maxSize: maxSize}
+	records, err := o.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r.Seq > o.seq {
+			o.seq = r.Seq
+		}
+	}
+	if fi, err := os.Stat(fileName); err == nil {
+		o.size = fi.Size()
+	}
+	return o, nil
+}
+
+// push append the report with the batch id, the oldest records are dropped if the file exceeds maxSize
+func (o *outbox) push(batch string, list []common.Metrics) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+	r := outboxRecord{Seq: o.seq, Batch: batch, Metrics: list}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if o.size+int64(len(line)) > o.maxSize {
+		return o.compact(r, int64(len(line)))
+	}
+
+	f, err := os.OpenFile(o.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := f.Write(line)
+	o.size += int64(n)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// replay send records in order and remove delivered ones,
+// stops on the first retriable error and returns it, the rest is kept for the next replay
+// records rejected by the server are dropped
+func (o *outbox) replay(send func(batch string, list []common.Metrics) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.size == 0 {
+		return nil
+	}
+
+	records, err := o.read()
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	i := 0
+	for ; i < len(records); i++ {
+		if sendErr = send(records[i].Batch, records[i].Metrics); sendErr != nil {
+			if isRetriable(sendErr) {
+				break
+			}
+			log.Printf("outbox record %d dropped: %v", records[i].Seq, sendErr)
+			sendErr = nil
+		}
+	}
+	if err = o.write(records[i:]); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// compact rewrite the file with the new record and the newest records fitting maxSize
+// must be called under o.mu
+func (o *outbox) compact(r outboxRecord, lineSize int64) error {
+	if lineSize > o.maxSize {
+		log.Printf("outbox record %d is bigger than the outbox, dropped with %d counter deltas",
+			r.Seq, counterDeltas([]outboxRecord{r}))
+		return nil
+	}
+	records, err := o.read()
+	if err != nil {
+		return err
+	}
+	size := lineSize
+	first := len(records)
+	for first > 0 {
+		b, err := json.Marshal(records[first-1])
+		if err != nil {
+			return err
+		}
+		if size+int64(len(b))+1 > o.maxSize {
+			break
+		}
+		size += int64(len(b)) + 1
+		first--
+	}
+	if first > 0 {
+		log.Printf("outbox is full, %d oldest records dropped with %d counter deltas",
+			first, counterDeltas(records[:first]))
+	}
+	return o.write(append(records[first:], r))
+}
+
+// counterDeltas the number of counter deltas in records, they are lost if records are dropped
+func counterDeltas(records []outboxRecord) int {
+	n := 0
+	for _, r := range records {
+		for _, m := range r.Metrics {
+			if m.MType == common.MTypeCounter && m.Delta != nil {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// read all records, broken lines are skipped
+// must be called under o.mu or in the constructor
+func (o *outbox) read() ([]outboxRecord, error) {
+	f, err := os.Open(o.fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []outboxRecord
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) != 0 {
+			var r outboxRecord
+			if errJSON := json.Unmarshal(line, &r); errJSON == nil {
+				records = append(records, r)
+			} else {
+				log.Println("outbox broken record skipped:", errJSON)
+			}
+		}
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// write replace the file by records
+// must be called under o.mu
+func (o *outbox) write(records []outboxRecord) error {
+	var buf bytes.Buffer
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	tmp := o.fileName + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, o.fileName); err != nil {
+		return err
+	}
+	o.size = int64(buf.Len())
+	return nil
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func outboxReport(id string) []common.Metrics {
+	value := 1.0
+	delta := int64(1)
+	return []common.Metrics{
+		{ID: id, MType: common.MTypeGauge, Value: &value},
+		{ID: "PollCount", MType: common.MTypeCounter, Delta: &delta},
+	}
+}
+
+func TestOutbox_Replay(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := newOutbox(fileName, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"A", "B", "C"} {
+		if err = o.push("batch-"+id, outboxReport(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the server is down after the first record
+	var sent []string
+	down := &net.OpError{Op: "dial", Err: net.UnknownNetworkError("down")}
+	err = o.replay(func(batch string, list []common.Metrics) error {
+		if len(sent) == 1 {
+			return down
+		}
+		if len(list) != 2 || list[1].MType != common.MTypeCounter {
+			t.Errorf("replay() sent %+v, want the gauge and the counter", list)
+		}
+		if batch != "batch-"+list[0].ID {
+			t.Errorf("replay() batch = %q, want batch-%s", batch, list[0].ID)
+		}
+		sent = append(sent, list[0].ID)
+		return nil
+	})
+	if err != down {
+		t.Fatalf("replay() error = %v, want %v", err, down)
+	}
+
+	// records are kept after the restart
+	if o, err = newOutbox(fileName, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.push("batch-D", outboxReport("D")); err != nil {
+		t.Fatal(err)
+	}
+	if err = o.replay(func(batch string, list []common.Metrics) error {
+		sent = append(sent, list[0].ID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sent, ","); got != "A,B,C,D" {
+		t.Errorf("replay() order = %s", got)
+	}
+	if o.size != 0 {
+		t.Errorf("outbox size after replay = %d, want 0", o.size)
+	}
+}
+
+func TestOutbox_MaxSize(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := newOutbox(fileName, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.push("batch-A", outboxReport("A")); err != nil {
+		t.Fatal(err)
+	}
+	// room for two records only
+	o.maxSize = o.size*2 + 1
+	for _, id := range []string{"B", "C"} {
+		if err = o.push("batch-"+id, outboxReport(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if o.size > o.maxSize {
+		t.Errorf("outbox size = %d, max %d", o.size, o.maxSize)
+	}
+	var sent []string
+	if err = o.replay(func(batch string, list []common.Metrics) error {
+		sent = append(sent, list[0].ID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sent, ","); got != "B,C" {
+		t.Errorf("replay() after the overflow = %s, want B,C", got)
+	}
+}
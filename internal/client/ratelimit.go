@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// rateLimiter allows the limited number of events per second
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+// newRateLimiter the constructor, perSecond <= 0 means no limit
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Second / time.Duration(perSecond))}
+}
+
+// wait for the next allowed event or ctx done, the nil limiter does not wait
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil || l.ticker == nil {
+		return nil
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop release the ticker
+func (l *rateLimiter) stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
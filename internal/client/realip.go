@@ -0,0 +1,19 @@
+package client
+
+import (
+	"net"
+)
+
+// realIPHeader the address of the agent checked by the trusted subnet of the server
+const realIPHeader = "X-Real-IP"
+
+// outboundIP the address of the interface connecting to the server,
+// the UDP socket is not connected really, so no packets are sent
+func outboundIP(addr string) (string, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
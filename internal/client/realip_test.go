@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_outboundIP(t *testing.T) {
+	ip, err := outboundIP("127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "127.0.0.1" {
+		t.Errorf("outboundIP() = %s, want 127.0.0.1", ip)
+	}
+	if _, err = outboundIP("127.0.0.1"); err == nil {
+		t.Error("outboundIP() without port must fail")
+	}
+}
+
+func TestMetricsEngine_sendRealIP(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(realIPHeader)
+	}))
+	defer srv.Close()
+
+	m := &metricsEngine{cfg: Config{Addr: strings.TrimPrefix(srv.URL, "http://")}, client: resty.New(), realIP: "192.168.1.10"}
+	if err := m.send(context.Background(), "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != "192.168.1.10" {
+		t.Errorf("%s = %q, want 192.168.1.10", realIPHeader, got)
+	}
+}
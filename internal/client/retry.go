@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// retryDelays pauses between attempts to deliver the report
+var retryDelays = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
+// retryJitter the part of the delay added or subtracted randomly
+const retryJitter = 0.2
+
+// statusError the server response with the error status
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.code, e.body)
+}
+
+// isRetriable true for network errors, timeouts, 5xx responses
+// and gRPC errors of the same kind
+func isRetriable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500
+	}
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.Unknown:
+			return true
+		}
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// withRetry call f until it succeeds, the error is not retriable, attempts are over or ctx is done
+func withRetry(ctx context.Context, f func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := f(ctx)
+		if err == nil || ctx.Err() != nil || !isRetriable(err) || attempt >= len(retryDelays) {
+			return err
+		}
+		delay := jitter(retryDelays[attempt])
+		log.Printf("attempt %d: %v, retry in %v", attempt+1, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// jitter the random delay around d
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*2-1)*retryJitter*float64(d))
+}
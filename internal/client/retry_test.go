@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetricsEngine_sendRetry(t *testing.T) {
+	saved := retryDelays
+	retryDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+	defer func() { retryDelays = saved }()
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, wantAttempts: 1},
+		{name: "5xx retried", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, wantAttempts: 3},
+		{name: "4xx not retried", statuses: []int{http.StatusBadRequest}, wantAttempts: 1, wantErr: true},
+		{name: "attempts are over", statuses: []int{http.StatusServiceUnavailable}, wantAttempts: 4, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&attempts, 1))
+				if got := r.Header.Get(batchIDHeader); got != "run-1" {
+					t.Errorf("attempt %d %s = %q, want run-1", n, batchIDHeader, got)
+				}
+				if n > len(tt.statuses) {
+					n = len(tt.statuses)
+				}
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			m := &metricsEngine{cfg: Config{Addr: strings.TrimPrefix(srv.URL, "http://")}, client: resty.New()}
+			err := m.send(context.Background(), "run-1", nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("send() attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestMetricsEngine_sendRetryStopped(t *testing.T) {
+	// nobody listens the port
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	m := &metricsEngine{cfg: Config{Addr: addr}, client: resty.New()}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := m.send(ctx, "", nil)
+	if err == nil {
+		t.Fatal("send() to the closed port must fail")
+	}
+	if !isRetriable(err) && !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("send() error = %v, want the network error", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("send() took %v, must stop with the context", d)
+	}
+}
+
+func Test_jitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Second); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jitter() = %v out of 20%%", d)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package common
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"reflect"
 	"strconv"
+	"time"
 )
 
 const (
@@ -55,10 +59,64 @@ func ValidMetricsName() []string {
 }
 
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID      string     `json:"id"`                // имя метрики
+	MType   string     `json:"type"`              // параметр, принимающий значение gauge или counter
+	Delta   *int64     `json:"delta,omitempty"`   // значение метрики в случае передачи counter
+	Value   *float64   `json:"value,omitempty"`   // значение метрики в случае передачи gauge
+	Hash    string     `json:"hash,omitempty"`    // значение хеш-функции
+	Source  string     `json:"source,omitempty"`  // агент или хост, приславший метрику
+	Updated *time.Time `json:"updated,omitempty"` // время последнего обновления на сервере
+}
+
+// Key the metric key in the storage, metrics of different sources are stored separately
+type Key struct {
+	Source string
+	ID     string
+}
+
+// Key of the metric
+func (m *Metrics) Key() Key {
+	return Key{Source: m.Source, ID: m.ID}
+}
+
+// CalcHash HMAC-SHA256 of id, type, value and source if it is set, signed by the key, returns hex string
+// the data is "id:type:value", ":source" is appended only for metrics with the source,
+// so hashes of metrics without the source are the same as before sources
+func (m *Metrics) CalcHash(key string) (string, error) {
+	var data string
+	switch {
+	case m.MType == MTypeGauge && m.Value != nil:
+		data = fmt.Sprintf("%s:%s:%s", m.ID, m.MType, strconv.FormatFloat(*m.Value, 'f', -1, 64))
+	case m.MType == MTypeCounter && m.Delta != nil:
+		data = fmt.Sprintf("%s:%s:%d", m.ID, m.MType, *m.Delta)
+	default:
+		return "", errors.New("CalcHash: wrong metric " + m.ID)
+	}
+	if m.Source != "" {
+		data += ":" + m.Source
+	}
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SetHash fill the Hash field, MType and value must be filled before the call
+func (m *Metrics) SetHash(key string) error {
+	hash, err := m.CalcHash(key)
+	if err != nil {
+		return err
+	}
+	m.Hash = hash
+	return nil
+}
+
+// CheckHash true if the Hash field is valid for the key
+func (m *Metrics) CheckHash(key string) bool {
+	hash, err := m.CalcHash(key)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(hash), []byte(m.Hash))
 }
 
 // SetStrValue MType must be filled before the call
@@ -86,41 +144,30 @@ func (m *Metrics) SetStrValue(value string) error {
 	return nil
 }
 
-// SetAnyValue MType must be filled before the call
-// value must be int64 or float64
-func (m *Metrics) SetAnyValue(value any) error {
-	v := reflect.ValueOf(value)
-	switch m.MType {
-	case MTypeGauge:
-		switch v.Kind() {
-		case reflect.Float64:
-			if m.Value == nil {
-				m.Value = new(float64)
-			}
-			*m.Value = value.(float64)
-			return nil
-		case reflect.Int64:
-			if m.Value == nil {
-				m.Value = new(float64)
-			}
-			*m.Value = float64(value.(int64))
-			return nil
-		}
-	case MTypeCounter:
-		switch v.Kind() {
-		case reflect.Int64:
-			if m.Delta == nil {
-				m.Delta = new(int64)
-			}
-			*m.Delta = value.(int64)
-			return nil
-		}
+// StrValue the value as string, empty if the value is not set
+func (m *Metrics) StrValue() string {
+	switch {
+	case m.MType == MTypeGauge && m.Value != nil:
+		return fmt.Sprintf("%v", *m.Value)
+	case m.MType == MTypeCounter && m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10)
 	}
-	return fmt.Errorf("SetAnyValue: MType=%s ID=%s wrong type %v", m.MType, m.ID, v.Kind())
+	return ""
+}
+
+// NewGauge the gauge metric
+func NewGauge(id string, value float64) Metrics {
+	return Metrics{ID: id, MType: MTypeGauge, Value: &value}
+}
+
+// NewCounter the counter metric
+func NewCounter(id string, delta int64) Metrics {
+	return Metrics{ID: id, MType: MTypeCounter, Delta: &delta}
 }
 
 type Command struct {
 	Metrics
+	Stale    bool `json:"stale,omitempty"` // the metric is not updated for a long time, the value response only
 	CType    int  `json:"-"`
 	JSONResp bool `json:"-"`
 }
@@ -1,9 +1,13 @@
 package common
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestCounter_String(t *testing.T) {
@@ -47,3 +51,90 @@ func TestGauge_String(t *testing.T) {
 		})
 	}
 }
+
+func TestMetrics_Hash(t *testing.T) {
+	delta := int64(10)
+	value := 1.25
+	tests := []struct {
+		name string
+		m    Metrics
+	}{
+		{
+			name: "counter",
+			m:    Metrics{ID: "PollCount", MType: MTypeCounter, Delta: &delta},
+		},
+		{
+			name: "gauge",
+			m:    Metrics{ID: "Alloc", MType: MTypeGauge, Value: &value},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.m.SetHash("secret"); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.m.CheckHash("secret") {
+				t.Errorf("CheckHash() with the same key = false")
+			}
+			if tt.m.CheckHash("other") {
+				t.Errorf("CheckHash() with other key = true")
+			}
+		})
+	}
+	m := Metrics{ID: "Alloc", MType: MTypeGauge}
+	if m.SetHash("secret") == nil {
+		t.Errorf("SetHash() without value must fail")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "10s", want: 10 * time.Second},
+		{s: "1m30s", want: 90 * time.Second},
+		{s: "300", want: 300 * time.Second},
+		{s: "ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseDuration(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics_HashSource(t *testing.T) {
+	m := NewGauge("Alloc", 1.5)
+	m.Source = "host-1"
+	if err := m.SetHash("secret"); err != nil {
+		t.Fatal(err)
+	}
+	m.Source = "host-2"
+	if m.CheckHash("secret") {
+		t.Errorf("CheckHash() with other source = true")
+	}
+}
+
+func TestMetrics_HashWithoutSource(t *testing.T) {
+	// the format is not changed by sources
+	h := hmac.New(sha256.New, []byte("secret"))
+	h.Write([]byte("Alloc:gauge:1.5"))
+	want := hex.EncodeToString(h.Sum(nil))
+
+	m := NewGauge("Alloc", 1.5)
+	got, err := m.CalcHash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("CalcHash() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ParseDuration parse the duration with units like "10s", the number without units is seconds
+func ParseDuration(s string) (time.Duration, error) {
+	if v, err := strconv.Atoi(s); err == nil {
+		return time.Duration(v) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use units like 10s", s)
+	}
+	return d, nil
+}
+
+// DurationValue the flag.Value of the duration parsed by ParseDuration
+type DurationValue struct {
+	D *time.Duration
+}
+
+func (v DurationValue) String() string {
+	if v.D == nil {
+		return ""
+	}
+	return v.D.String()
+}
+
+func (v DurationValue) Set(s string) error {
+	d, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v.D = d
+	return nil
+}
+
+// EnvString set p if the environment variable exists, the empty value is set too
+func EnvString(name string, p *string) {
+	if s, ok := os.LookupEnv(name); ok {
+		*p = s
+	}
+}
+
+// EnvDuration set p if the environment variable is not empty
+func EnvDuration(name string, p *time.Duration) error {
+	if s := os.Getenv(name); s != "" {
+		d, err := ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*p = d
+	}
+	return nil
+}
+
+// EnvBool set p if the environment variable is not empty
+func EnvBool(name string, p *bool) error {
+	if s := os.Getenv(name); s != "" {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*p = v
+	}
+	return nil
+}
+
+// EnvInt set p if the environment variable is not empty
+func EnvInt(name string, p *int) error {
+	if s := os.Getenv(name); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*p = v
+	}
+	return nil
+}
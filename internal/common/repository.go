@@ -0,0 +1,134 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound the metric is not stored
+	ErrNotFound = errors.New("metric not found")
+	// ErrTypeMismatch the metric is stored with other type
+	ErrTypeMismatch = errors.New("stored metric has other type")
+	// ErrWrongMetric the metric has unknown type or has not value
+	ErrWrongMetric = errors.New("wrong metric")
+)
+
+// ItemError the error of one metric of the batch
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// Repository the metrics storage of the server
+type Repository interface {
+	// Update set the gauge value or add the counter delta
+	// the update time is m.Updated if it is set (e.g. on restore) or the current time,
+	// m is filled by the stored value and the update time after the update
+	Update(ctx context.Context, m *Metrics) error
+	// UpdateBatch apply all metrics or nothing, the error of the item is *ItemError
+	UpdateBatch(ctx context.Context, list []Metrics) error
+	// Get fill the value of m by Source, ID and MType
+	Get(ctx context.Context, m *Metrics) error
+	// List all stored metrics of all sources
+	List(ctx context.Context) ([]Metrics, error)
+	// Expire delete metrics updated before the time, returns the number of deleted metrics
+	Expire(ctx context.Context, before time.Time) (int, error)
+	// Close release resources
+	Close() error
+}
+
+// MemRepository the Repository in the memory
+type MemRepository struct {
+	mu      sync.Mutex // serializes updates
+	storage *Storage
+}
+
+var _ Repository = (*MemRepository)(nil)
+
+// NewMemRepository the constructor
+func NewMemRepository() *MemRepository {
+	return &MemRepository{storage: NewStorage()}
+}
+
+// Update implementation the Repository
+func (r *MemRepository) Update(_ context.Context, m *Metrics) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := check(m); err != nil {
+		return err
+	}
+	r.apply(m)
+	return nil
+}
+
+// UpdateBatch implementation the Repository
+func (r *MemRepository) UpdateBatch(_ context.Context, list []Metrics) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range list {
+		if err := check(&list[i]); err != nil {
+			return &ItemError{Index: i, Err: err}
+		}
+	}
+	for i := range list {
+		r.apply(&list[i])
+	}
+	return nil
+}
+
+// Get implementation the Repository
+func (r *MemRepository) Get(_ context.Context, m *Metrics) error {
+	return r.storage.Get(m)
+}
+
+// List implementation the Repository
+func (r *MemRepository) List(_ context.Context) ([]Metrics, error) {
+	return r.storage.List(), nil
+}
+
+// Expire implementation the Repository
+func (r *MemRepository) Expire(_ context.Context, before time.Time) (int, error) {
+	return r.storage.Expire(before), nil
+}
+
+// Close implementation the Repository
+func (r *MemRepository) Close() error {
+	return nil
+}
+
+// check the metric can be applied
+func check(m *Metrics) error {
+	switch {
+	case m.MType == MTypeGauge && m.Value != nil:
+	case m.MType == MTypeCounter && m.Delta != nil:
+	default:
+		return ErrWrongMetric
+	}
+	return nil
+}
+
+// apply set the gauge or add the counter, fill m by the stored value and the update time
+func (r *MemRepository) apply(m *Metrics) {
+	t := time.Now()
+	if m.Updated != nil {
+		t = *m.Updated
+	}
+	m.Updated = &t
+	if m.MType == MTypeCounter {
+		*m.Delta = r.storage.AddCounter(m.Key(), *m.Delta, t)
+		return
+	}
+	r.storage.SetGauge(m.Key(), *m.Value, t)
+}
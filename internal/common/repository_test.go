@@ -0,0 +1,72 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemRepository_UpdateBatch(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemRepository()
+	value := 1.5
+	delta := int64(2)
+	if err := r.Update(ctx, &Metrics{ID: "G", MType: MTypeGauge, Value: &value}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the counter without delta is wrong, nothing is applied
+	err := r.UpdateBatch(ctx, []Metrics{
+		{ID: "C", MType: MTypeCounter, Delta: &delta},
+		{ID: "G", MType: MTypeCounter},
+	})
+	var itemErr *ItemError
+	if !errors.As(err, &itemErr) || itemErr.Index != 1 || !errors.Is(err, ErrWrongMetric) {
+		t.Fatalf("UpdateBatch() error = %v, want item 1 wrong metric", err)
+	}
+	if err = r.Get(ctx, &Metrics{ID: "C", MType: MTypeCounter}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after the wrong batch error = %v, want %v", err, ErrNotFound)
+	}
+
+	d1, d2 := int64(2), int64(3)
+	if err = r.UpdateBatch(ctx, []Metrics{
+		{ID: "C", MType: MTypeCounter, Delta: &d1},
+		{ID: "C", MType: MTypeCounter, Delta: &d2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	m := Metrics{ID: "C", MType: MTypeCounter}
+	if err = r.Get(ctx, &m); err != nil || *m.Delta != 5 {
+		t.Errorf("Get() = %v, %v, want 5", m.StrValue(), err)
+	}
+}
+
+func TestMemRepository_SameID(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemRepository()
+	value := 1.5
+	delta := int64(2)
+	if err := r.Update(ctx, &Metrics{ID: "X", MType: MTypeGauge, Value: &value}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, &Metrics{ID: "X", MType: MTypeCounter}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Get() counter of the gauge error = %v, want %v", err, ErrTypeMismatch)
+	}
+
+	// the gauge and the counter with the same ID are stored separately
+	if err := r.Update(ctx, &Metrics{ID: "X", MType: MTypeCounter, Delta: &delta}); err != nil {
+		t.Fatal(err)
+	}
+	g := Metrics{ID: "X", MType: MTypeGauge}
+	if err := r.Get(ctx, &g); err != nil || *g.Value != 1.5 {
+		t.Errorf("Get() gauge = %v, %v, want 1.5", g.StrValue(), err)
+	}
+	c := Metrics{ID: "X", MType: MTypeCounter}
+	if err := r.Get(ctx, &c); err != nil || *c.Delta != 2 {
+		t.Errorf("Get() counter = %v, %v, want 2", c.StrValue(), err)
+	}
+	list, _ := r.List(ctx)
+	if len(list) != 2 || list[0].MType != MTypeGauge || list[1].MType != MTypeCounter {
+		t.Errorf("List() = %+v, want the gauge and the counter", list)
+	}
+}
@@ -1,85 +1,157 @@
 package common
 
 import (
-	"errors"
 	"fmt"
-	"reflect"
+	"sort"
 	"sync"
+	"time"
 )
 
-// Getter interface
-type Getter interface {
-	// Get input is 'key'
-	Get(string) (any, bool)
-	GetNames() []string
+// gaugeEntry the stored gauge
+type gaugeEntry struct {
+	value   float64
+	updated time.Time
 }
 
-// Setter interface
-type Setter interface {
-	// Set first param is the 'key', all next params (values) must be the same type
-	// all values will be added
-	Set(string, ...any)
+// counterEntry the stored counter
+type counterEntry struct {
+	value   int64
+	updated time.Time
 }
 
-// Storage the thread-safe storage
+// Storage the thread-safe storage of gauges and counters by Key with the time of the last update
+// a gauge and a counter may have the same ID
 type Storage struct {
-	sync.RWMutex
-	data map[string]any
+	mu       sync.RWMutex
+	gauges   map[Key]gaugeEntry
+	counters map[Key]counterEntry
 }
 
 // NewStorage the constructor
 func NewStorage() *Storage {
-	s := Storage{}
-	s.data = make(map[string]any)
-	return &s
+	return &Storage{
+		gauges:   make(map[Key]gaugeEntry),
+		counters: make(map[Key]counterEntry),
+	}
 }
 
-// Set implementation the Setter
-func (s *Storage) Set(key string, values ...any) error {
-	const pre = "Storage.Set()"
-	if len(values) == 0 {
-		return errors.New(pre + " nothing to set")
-	}
-	// calc sum of values
-	sum := values[0]
-	sumReflectValue := reflect.ValueOf(sum).Kind()
-	for i := 1; i < len(values); i++ {
-		v := reflect.ValueOf(values[i])
-		if sumReflectValue != v.Kind() {
-			return errors.New(pre + " values of different types")
+// SetGauge set the gauge value updated at t
+func (s *Storage) SetGauge(k Key, value float64, t time.Time) {
+	s.mu.Lock()
+	s.gauges[k] = gaugeEntry{value: value, updated: t}
+	s.mu.Unlock()
+}
+
+// SetCounter set the counter value updated at t
+func (s *Storage) SetCounter(k Key, value int64, t time.Time) {
+	s.mu.Lock()
+	s.counters[k] = counterEntry{value: value, updated: t}
+	s.mu.Unlock()
+}
+
+// AddCounter add the delta to the counter updated at t, returns the new value
+func (s *Storage) AddCounter(k Key, delta int64, t time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.counters[k]
+	e.value += delta
+	e.updated = t
+	s.counters[k] = e
+	return e.value
+}
+
+// Gauge the gauge value
+func (s *Storage) Gauge(k Key) (float64, bool) {
+	s.mu.RLock()
+	e, ok := s.gauges[k]
+	s.mu.RUnlock()
+	return e.value, ok
+}
+
+// Counter the counter value
+func (s *Storage) Counter(k Key) (int64, bool) {
+	s.mu.RLock()
+	e, ok := s.counters[k]
+	s.mu.RUnlock()
+	return e.value, ok
+}
+
+// Get fill the value and the update time of m by Source, ID and MType
+// returns ErrTypeMismatch if only the metric of other type has the ID
+func (s *Storage) Get(m *Metrics) error {
+	k := m.Key()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch m.MType {
+	case MTypeGauge:
+		if e, ok := s.gauges[k]; ok {
+			m.Value = &e.value
+			m.Updated = &e.updated
+			return nil
+		}
+		if _, ok := s.counters[k]; ok {
+			return fmt.Errorf("%w: %s is %s", ErrTypeMismatch, m.ID, MTypeCounter)
+		}
+	case MTypeCounter:
+		if e, ok := s.counters[k]; ok {
+			m.Delta = &e.value
+			m.Updated = &e.updated
+			return nil
 		}
-		switch sumReflectValue {
-		case reflect.Float64:
-			sum = sum.(float64) + v.Float()
-		case reflect.Int64:
-			sum = sum.(int64) + v.Int()
-		default:
-			return fmt.Errorf("%s %v not implemented", pre, v.Kind())
+		if _, ok := s.gauges[k]; ok {
+			return fmt.Errorf("%w: %s is %s", ErrTypeMismatch, m.ID, MTypeGauge)
 		}
+	default:
+		return ErrWrongMetric
 	}
-	s.Lock()
-	s.data[key] = sum
-	s.Unlock()
-	return nil
+	return ErrNotFound
 }
 
-// Get implementation the Getter
-func (s *Storage) Get(name string) (any, bool) {
-	s.RLock()
-	v, ok := s.data[name]
-	s.RUnlock()
-	return v, ok
+// List all metrics, gauges then counters sorted by source and ID
+func (s *Storage) List() []Metrics {
+	s.mu.RLock()
+	list := make([]Metrics, 0, len(s.gauges)+len(s.counters))
+	for k, e := range s.gauges {
+		m := NewGauge(k.ID, e.value)
+		m.Source = k.Source
+		m.Updated = &e.updated
+		list = append(list, m)
+	}
+	for k, e := range s.counters {
+		m := NewCounter(k.ID, e.value)
+		m.Source = k.Source
+		m.Updated = &e.updated
+		list = append(list, m)
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].MType != list[j].MType {
+			return list[i].MType == MTypeGauge
+		}
+		if list[i].Source != list[j].Source {
+			return list[i].Source < list[j].Source
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
 }
 
-// GetNames implementation the Getter
-func (s *Storage) GetNames() []string {
-	names := make([]string, len(s.data))
-	i := 0
-	s.RLock()
-	for k := range s.data {
-		names[i] = k
-		i++
+// Expire delete metrics updated before the time, returns the number of deleted metrics
+func (s *Storage) Expire(before time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k, e := range s.gauges {
+		if e.updated.Before(before) {
+			delete(s.gauges, k)
+			n++
+		}
+	}
+	for k, e := range s.counters {
+		if e.updated.Before(before) {
+			delete(s.counters, k)
+			n++
+		}
 	}
-	s.RUnlock()
-	return names
+	return n
 }
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates of tests
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA the CA, its certificate is written to ca.pem of dir
+func newTestCA(t *testing.T, dir string) *testCA {
+	ca := &testCA{dir: dir}
+	ca.cert, ca.key = ca.issue(t, "ca", &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	return ca
+}
+
+// issue the certificate signed by the CA, the certificate and the key are written to name.pem and name-key.pem
+func (ca *testCA) issue(t *testing.T, name string, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = serial
+	tmpl.Subject = pkix.Name{CommonName: name}
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := tmpl, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.write(t, name+".pem", "CERTIFICATE", der)
+	ca.write(t, name+"-key.pem", "PRIVATE KEY", keyDER)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func (ca *testCA) write(t *testing.T, name, typ string, b []byte) {
+	if err := os.WriteFile(filepath.Join(ca.dir, name), pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+func TestTLSConfig(t *testing.T) {
+	ca := newTestCA(t, t.TempDir())
+	ca.issue(t, "server", &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	ca.issue(t, "agent", &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	other := newTestCA(t, t.TempDir())
+
+	tests := []struct {
+		name     string
+		clientCA string
+		ca       string
+		cert     string
+		key      string
+		wantErr  bool
+	}{
+		{name: "tls", ca: ca.path("ca.pem")},
+		{name: "mtls", clientCA: ca.path("ca.pem"), ca: ca.path("ca.pem"), cert: ca.path("agent.pem"), key: ca.path("agent-key.pem")},
+		{name: "mtls without client certificate", clientCA: ca.path("ca.pem"), ca: ca.path("ca.pem"), wantErr: true},
+		{name: "unknown server", ca: other.path("ca.pem"), wantErr: true},
+		{name: "unknown client", clientCA: other.path("ca.pem"), ca: ca.path("ca.pem"), cert: ca.path("agent.pem"), key: ca.path("agent-key.pem"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			cfg, err := ServerTLSConfig(ca.path("server.pem"), ca.path("server-key.pem"), tt.clientCA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv.TLS = cfg
+			srv.StartTLS()
+			defer srv.Close()
+
+			clientCfg, err := ClientTLSConfig(tt.ca, tt.cert, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+			resp, err := client.Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	newTestCA(t, dir)
+	if _, err := LoadCertPool(filepath.Join(dir, "ca.pem")); err != nil {
+		t.Error(err)
+	}
+	if _, err := LoadCertPool(filepath.Join(dir, "ca-key.pem")); err == nil {
+		t.Error("LoadCertPool() of the key must fail")
+	}
+	if _, err := LoadCertPool(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("LoadCertPool() of the missing file must fail")
+	}
+}
@@ -0,0 +1,149 @@
+// Package envelope the hybrid encryption of request bodies,
+// the body is encrypted by the random AES-256-GCM key, the key is encrypted by RSA-OAEP
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// Header the request header of the encrypted body, the value is Scheme
+	Header = "X-Encryption"
+	// Scheme the only supported scheme
+	Scheme = "rsa-oaep-aes256-gcm"
+
+	keySize = 32
+)
+
+// ErrMalformed the envelope is truncated or broken
+var ErrMalformed = errors.New("malformed envelope")
+
+// Seal encrypt b by the public key
+// the envelope is the length of the encrypted key as uint16, the encrypted key, the nonce and the ciphertext
+func Seal(pub *rsa.PublicKey, b []byte) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	encKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(encKey)+len(nonce)+len(b)+gcm.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(encKey)))
+	out = append(out, encKey...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, b, nil), nil
+}
+
+// Open decrypt the envelope by the private key
+func Open(priv *rsa.PrivateKey, b []byte) ([]byte, error) {
+	if len(b) < 2 {
+		return nil, ErrMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < n {
+		return nil, ErrMalformed
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, b[:n], nil)
+	if err != nil {
+		return nil, err
+	}
+	b = b[n:]
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadPublicKey read the PEM file of the PKIX or PKCS #1 public key
+func LoadPublicKey(fileName string) (*rsa.PublicKey, error) {
+	block, err := readPEM(fileName)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not RSA public key", fileName)
+		}
+		return pub, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", fileName, block.Type)
+	}
+}
+
+// LoadPrivateKey read the PEM file of the PKCS #8 or PKCS #1 private key
+func LoadPrivateKey(fileName string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(fileName)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not RSA private key", fileName)
+		}
+		return priv, nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", fileName, block.Type)
+	}
+}
+
+func readPEM(fileName string) (*pem.Block, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: PEM data is not found", fileName)
+	}
+	return block, nil
+}
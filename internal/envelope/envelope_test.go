@@ -0,0 +1,103 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{name: "empty", b: []byte{}},
+		{name: "small", b: []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)},
+		// bigger than RSA can encrypt
+		{name: "big", b: bytes.Repeat([]byte("metric"), 100000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := Seal(&priv.PublicKey, tt.b)
+			require.NoError(t, err)
+			got, err := Open(priv, sealed)
+			require.NoError(t, err)
+			assert.Equal(t, string(tt.b), string(got))
+		})
+	}
+}
+
+func TestOpen_Broken(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	sealed, err := Seal(&priv.PublicKey, []byte("metrics"))
+	require.NoError(t, err)
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name string
+		priv *rsa.PrivateKey
+		b    []byte
+	}{
+		{name: "empty", priv: priv, b: nil},
+		{name: "truncated key", priv: priv, b: sealed[:100]},
+		{name: "truncated nonce", priv: priv, b: sealed[:2+256+4]},
+		{name: "tampered", priv: priv, b: tampered},
+		{name: "other key", priv: other, b: sealed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(tt.priv, tt.b)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	write := func(name, typ string, b []byte) string {
+		fileName := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600))
+		return fileName
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+
+	for _, fileName := range []string{
+		write("pkix.pem", "PUBLIC KEY", pkix),
+		write("pkcs1.pub", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&priv.PublicKey)),
+	} {
+		pub, err := LoadPublicKey(fileName)
+		require.NoError(t, err)
+		assert.True(t, priv.PublicKey.Equal(pub))
+	}
+	for _, fileName := range []string{
+		write("pkcs8.pem", "PRIVATE KEY", pkcs8),
+		write("pkcs1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv)),
+	} {
+		got, err := LoadPrivateKey(fileName)
+		require.NoError(t, err)
+		assert.True(t, priv.Equal(got))
+	}
+
+	_, err = LoadPublicKey(write("cert.pem", "CERTIFICATE", []byte{1}))
+	assert.Error(t, err)
+	_, err = LoadPrivateKey(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+}
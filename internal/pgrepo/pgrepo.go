@@ -0,0 +1,215 @@
+package pgrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	_ "github.com/lib/pq"
+	"time"
+)
+
+// schema tables are unique by source and ID,
+// tables of the previous version keyed by ID only are migrated
+const schema = `
+CREATE TABLE IF NOT EXISTS gauges (
+	source  TEXT NOT NULL DEFAULT '',
+	id      TEXT NOT NULL,
+	value   DOUBLE PRECISION NOT NULL,
+	updated TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS counters (
+	source  TEXT NOT NULL DEFAULT '',
+	id      TEXT NOT NULL,
+	delta   BIGINT NOT NULL,
+	updated TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS updated TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS updated TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_pkey;
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_pkey;
+CREATE UNIQUE INDEX IF NOT EXISTS gauges_source_id ON gauges (source, id);
+CREATE UNIQUE INDEX IF NOT EXISTS counters_source_id ON counters (source, id);`
+
+const (
+	upsertGauge = `INSERT INTO gauges (source, id, value, updated) VALUES ($1, $2, $3, $4)
+ON CONFLICT (source, id) DO UPDATE SET value = EXCLUDED.value, updated = EXCLUDED.updated
+RETURNING value`
+	incrementCounter = `INSERT INTO counters (source, id, delta, updated) VALUES ($1, $2, $3, $4)
+ON CONFLICT (source, id) DO UPDATE SET delta = counters.delta + EXCLUDED.delta, updated = EXCLUDED.updated
+RETURNING delta`
+	selectGauge    = `SELECT value, updated FROM gauges WHERE source = $1 AND id = $2`
+	selectCounter  = `SELECT delta, updated FROM counters WHERE source = $1 AND id = $2`
+	selectGauges   = `SELECT source, id, value, updated FROM gauges`
+	selectCounters = `SELECT source, id, delta, updated FROM counters`
+	deleteGauges   = `DELETE FROM gauges WHERE updated < $1`
+	deleteCounters = `DELETE FROM counters WHERE updated < $1`
+)
+
+// Repository the common.Repository in PostgreSQL, the table per metric type
+type Repository struct {
+	db *sql.DB
+}
+
+var _ common.Repository = (*Repository)(nil)
+
+// New connect to the database by dsn and create tables
+func New(ctx context.Context, dsn string) (*Repository, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.PingContext(ctx); err == nil {
+		_, err = db.ExecContext(ctx, schema)
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Repository{db: db}, nil
+}
+
+// querier the common part of sql.DB and sql.Tx
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Update implementation the common.Repository
+func (r *Repository) Update(ctx context.Context, m *common.Metrics) error {
+	return update(ctx, r.db, m)
+}
+
+// UpdateBatch implementation the common.Repository
+func (r *Repository) UpdateBatch(ctx context.Context, list []common.Metrics) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if err = update(ctx, tx, &list[i]); err != nil {
+			_ = tx.Rollback()
+			return &common.ItemError{Index: i, Err: err}
+		}
+	}
+	return tx.Commit()
+}
+
+// Get implementation the common.Repository
+func (r *Repository) Get(ctx context.Context, m *common.Metrics) error {
+	var err error
+	switch m.MType {
+	case common.MTypeGauge:
+		var v float64
+		var t time.Time
+		if err = r.db.QueryRowContext(ctx, selectGauge, m.Source, m.ID).Scan(&v, &t); err == nil {
+			m.Value = &v
+			m.Updated = &t
+		}
+	case common.MTypeCounter:
+		var v int64
+		var t time.Time
+		if err = r.db.QueryRowContext(ctx, selectCounter, m.Source, m.ID).Scan(&v, &t); err == nil {
+			m.Delta = &v
+			m.Updated = &t
+		}
+	default:
+		return common.ErrWrongMetric
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.ErrNotFound
+	}
+	return err
+}
+
+// List implementation the common.Repository
+func (r *Repository) List(ctx context.Context) ([]common.Metrics, error) {
+	list := make([]common.Metrics, 0)
+
+	rows, err := r.db.QueryContext(ctx, selectGauges)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		m := common.Metrics{MType: common.MTypeGauge, Value: new(float64), Updated: new(time.Time)}
+		if err = rows.Scan(&m.Source, &m.ID, m.Value, m.Updated); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	if err = rows.Close(); err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if rows, err = r.db.QueryContext(ctx, selectCounters); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		m := common.Metrics{MType: common.MTypeCounter, Delta: new(int64), Updated: new(time.Time)}
+		if err = rows.Scan(&m.Source, &m.ID, m.Delta, m.Updated); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	if err = rows.Close(); err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Expire implementation the common.Repository
+func (r *Repository) Expire(ctx context.Context, before time.Time) (int, error) {
+	n := 0
+	for _, query := range []string{deleteGauges, deleteCounters} {
+		res, err := r.db.ExecContext(ctx, query, before)
+		if err != nil {
+			return n, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+		n += int(rows)
+	}
+	return n, nil
+}
+
+// Close implementation the common.Repository
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// update upsert the gauge or increment the counter, fill m by the stored value and the update time
+func update(ctx context.Context, q querier, m *common.Metrics) error {
+	t := time.Now()
+	if m.Updated != nil {
+		t = *m.Updated
+	}
+	switch {
+	case m.MType == common.MTypeGauge && m.Value != nil:
+		var v float64
+		if err := q.QueryRowContext(ctx, upsertGauge, m.Source, m.ID, *m.Value, t).Scan(&v); err != nil {
+			return err
+		}
+		m.Value = &v
+	case m.MType == common.MTypeCounter && m.Delta != nil:
+		var v int64
+		if err := q.QueryRowContext(ctx, incrementCounter, m.Source, m.ID, *m.Delta, t).Scan(&v); err != nil {
+			return err
+		}
+		m.Delta = &v
+	default:
+		return common.ErrWrongMetric
+	}
+	m.Updated = &t
+	return nil
+}
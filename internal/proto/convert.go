@@ -0,0 +1,51 @@
+// Package proto the gRPC service of metrics, generated by protoc from metrics.proto
+package proto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import (
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FromMetrics the message of the metric
+func FromMetrics(m common.Metrics) *Metric {
+	x := &Metric{
+		Id:     m.ID,
+		Type:   m.MType,
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Hash:   m.Hash,
+		Source: m.Source,
+	}
+	if m.Updated != nil {
+		x.Updated = timestamppb.New(*m.Updated)
+	}
+	return x
+}
+
+// Metrics the metric of the message, the nil message is the empty metric
+func (x *Metric) Metrics() common.Metrics {
+	m := common.Metrics{
+		ID:     x.GetId(),
+		MType:  x.GetType(),
+		Hash:   x.GetHash(),
+		Source: x.GetSource(),
+	}
+	if x == nil {
+		return m
+	}
+	if x.Delta != nil {
+		delta := *x.Delta
+		m.Delta = &delta
+	}
+	if x.Value != nil {
+		value := *x.Value
+		m.Value = &value
+	}
+	if x.GetUpdated() != nil {
+		t := x.Updated.AsTime()
+		m.Updated = &t
+	}
+	return m
+}
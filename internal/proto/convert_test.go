@@ -0,0 +1,35 @@
+package proto
+
+import (
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMetric_Metrics(t *testing.T) {
+	updated := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	gauge := common.NewGauge("Alloc", 2.5)
+	gauge.Source = "host-1"
+	gauge.Hash = "hash"
+	gauge.Updated = &updated
+	zero := common.NewCounter("PollCount", 0)
+	tests := []struct {
+		name string
+		m    common.Metrics
+	}{
+		{name: "gauge", m: gauge},
+		{name: "zero counter", m: zero},
+		{name: "without value", m: common.Metrics{ID: "Alloc", MType: common.MTypeGauge}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.m, FromMetrics(tt.m).Metrics())
+		})
+	}
+}
+
+func TestMetric_MetricsNil(t *testing.T) {
+	var x *Metric
+	assert.Equal(t, common.Metrics{}, x.Metrics())
+}
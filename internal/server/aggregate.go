@@ -0,0 +1,216 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxQueryBuckets the limit of buckets of the query
+const maxQueryBuckets = 10000
+
+// Bucket the summary of samples of the time interval [T, T+step)
+type Bucket struct {
+	T      time.Time          `json:"t"`
+	Count  int                `json:"count"`
+	Values map[string]float64 `json:"values"`
+}
+
+// queryResponse the response of GET /query/
+type queryResponse struct {
+	ID      string   `json:"id"`
+	MType   string   `json:"type"`
+	Source  string   `json:"source,omitempty"`
+	Step    string   `json:"step,omitempty"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// queryHandler GET /query/{type}/{metric}?from=&to=&step=&fn=&source=
+// fn lists functions like min,max,avg,last,rate,p95, rate is per second and only for counters
+// without step the whole range is one bucket, buckets without samples are skipped
+func (s *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
+	req, status, err := s.parseHistoryRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	query := r.URL.Query()
+	fns, err := parseFuncs(query.Get("fn"), req.mType)
+	if err != nil {
+		http.Error(w, "fn: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if v := query.Get("step"); v != "" {
+		if step, err = common.ParseDuration(v); err != nil || step <= 0 {
+			http.Error(w, fmt.Sprintf("step: wrong value %q", v), http.StatusBadRequest)
+			return
+		}
+		if req.to.Sub(req.from)/step >= maxQueryBuckets {
+			http.Error(w, fmt.Sprintf("step: more than %d buckets", maxQueryBuckets), http.StatusBadRequest)
+			return
+		}
+	}
+
+	samples, ok := s.history.query(req.mType, req.key, req.from, req.to)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	resp := queryResponse{ID: req.key.ID, MType: req.mType, Source: req.key.Source, Buckets: make([]Bucket, 0)}
+	if step != 0 {
+		resp.Step = step.String()
+	}
+	for _, b := range bucketize(samples, req.from, step) {
+		resp.Buckets = append(resp.Buckets, Bucket{T: b.t, Count: len(b.samples), Values: aggregate(b.samples, fns)})
+	}
+	writeJSON(w, resp)
+}
+
+// defaultFuncs functions of the query without fn
+var defaultFuncs = []string{"min", "max", "avg", "last"}
+
+// parseFuncs the comma separated list of functions, rate is added for counters by default
+func parseFuncs(s, mType string) ([]string, error) {
+	if s == "" {
+		fns := append([]string(nil), defaultFuncs...)
+		if mType == common.MTypeCounter {
+			fns = append(fns, "rate")
+		}
+		return fns, nil
+	}
+	fns := strings.Split(s, ",")
+	for _, fn := range fns {
+		switch fn {
+		case "min", "max", "avg", "last":
+		case "rate":
+			if mType != common.MTypeCounter {
+				return nil, errors.New("rate is only for counters")
+			}
+		default:
+			if _, err := parsePercentile(fn); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fns, nil
+}
+
+// parsePercentile the percentile of functions like p95 or p99.9
+func parsePercentile(fn string) (float64, error) {
+	if strings.HasPrefix(fn, "p") {
+		if p, err := strconv.ParseFloat(fn[1:], 64); err == nil && p >= 0 && p <= 100 {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown function %q", fn)
+}
+
+// bucket samples of the interval starting at t
+type bucket struct {
+	t       time.Time
+	samples []Sample
+}
+
+// bucketize split samples in time order to buckets of step starting from, one bucket if step is 0
+func bucketize(samples []Sample, from time.Time, step time.Duration) []bucket {
+	if len(samples) == 0 {
+		return nil
+	}
+	if step == 0 {
+		return []bucket{{t: from, samples: samples}}
+	}
+	var buckets []bucket
+	for _, sample := range samples {
+		t := from.Add(sample.T.Sub(from) / step * step)
+		if n := len(buckets); n != 0 && buckets[n-1].t.Equal(t) {
+			buckets[n-1].samples = append(buckets[n-1].samples, sample)
+			continue
+		}
+		buckets = append(buckets, bucket{t: t, samples: []Sample{sample}})
+	}
+	return buckets
+}
+
+// aggregate calculate functions over not empty samples in time order,
+// rate is omitted if there are less than 2 samples
+func aggregate(samples []Sample, fns []string) map[string]float64 {
+	values := make(map[string]float64, len(fns))
+	var sorted []float64
+	for _, fn := range fns {
+		switch fn {
+		case "min":
+			v := samples[0].V
+			for _, s := range samples[1:] {
+				v = math.Min(v, s.V)
+			}
+			values[fn] = v
+		case "max":
+			v := samples[0].V
+			for _, s := range samples[1:] {
+				v = math.Max(v, s.V)
+			}
+			values[fn] = v
+		case "avg":
+			sum := 0.0
+			for _, s := range samples {
+				sum += s.V
+			}
+			values[fn] = sum / float64(len(samples))
+		case "last":
+			values[fn] = samples[len(samples)-1].V
+		case "rate":
+			if v, ok := rate(samples); ok {
+				values[fn] = v
+			}
+		default:
+			p, err := parsePercentile(fn)
+			if err != nil {
+				continue
+			}
+			if sorted == nil {
+				sorted = make([]float64, len(samples))
+				for i, s := range samples {
+					sorted[i] = s.V
+				}
+				sort.Float64s(sorted)
+			}
+			values[fn] = percentile(sorted, p)
+		}
+	}
+	return values
+}
+
+// rate the per second increase of the counter, the decrease is the reset of the counter
+func rate(samples []Sample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	seconds := samples[len(samples)-1].T.Sub(samples[0].T).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+	increase := 0.0
+	for i := 1; i < len(samples); i++ {
+		if d := samples[i].V - samples[i-1].V; d >= 0 {
+			increase += d
+		} else {
+			increase += samples[i].V
+		}
+	}
+	return increase / seconds, true
+}
+
+// percentile p of sorted values with the linear interpolation between closest ranks
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_aggregate(t *testing.T) {
+	start := time.Unix(1000, 0)
+	samples := func(values ...float64) []Sample {
+		list := make([]Sample, len(values))
+		for i, v := range values {
+			list[i] = Sample{T: start.Add(time.Duration(i) * time.Second), V: v}
+		}
+		return list
+	}
+	tests := []struct {
+		name    string
+		samples []Sample
+		fns     []string
+		want    map[string]float64
+	}{
+		{
+			name:    "summary",
+			samples: samples(3, 1, 4, 2),
+			fns:     []string{"min", "max", "avg", "last"},
+			want:    map[string]float64{"min": 1, "max": 4, "avg": 2.5, "last": 2},
+		},
+		{
+			name:    "percentiles",
+			samples: samples(5, 1, 4, 2, 3),
+			fns:     []string{"p0", "p50", "p100", "p90"},
+			want:    map[string]float64{"p0": 1, "p50": 3, "p100": 5, "p90": 4.6},
+		},
+		{
+			name:    "rate",
+			samples: samples(10, 12, 16),
+			fns:     []string{"rate"},
+			want:    map[string]float64{"rate": 3},
+		},
+		{
+			name:    "rate after the reset",
+			samples: samples(10, 12, 2),
+			fns:     []string{"rate"},
+			want:    map[string]float64{"rate": 2},
+		},
+		{
+			name:    "rate of one sample",
+			samples: samples(10),
+			fns:     []string{"rate", "last"},
+			want:    map[string]float64{"last": 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregate(tt.samples, tt.fns)
+			require.Len(t, got, len(tt.want))
+			for fn, v := range tt.want {
+				assert.InDelta(t, v, got[fn], 1e-9, fn)
+			}
+		})
+	}
+}
+
+func Test_parseFuncs(t *testing.T) {
+	fns, err := parseFuncs("", common.MTypeCounter)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"min", "max", "avg", "last", "rate"}, fns)
+
+	fns, err = parseFuncs("max,p99.9", common.MTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"max", "p99.9"}, fns)
+
+	for _, s := range []string{"rate", "p101", "median", "min,"} {
+		_, err = parseFuncs(s, common.MTypeGauge)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestQueryHandler(t *testing.T) {
+	s := &Server{repo: common.NewMemRepository(), history: newHistory(time.Hour, time.Second)}
+	start := time.Unix(1000, 0)
+	for i := 0; i < 6; i++ {
+		s.history.add([]common.Metrics{common.NewCounter("PollCount", int64(i*10))}, start.Add(time.Duration(i)*10*time.Second))
+	}
+	router := mux.NewRouter()
+	s.setHandlers(router)
+
+	tests := []struct {
+		name string
+		url  string
+		code int
+		want []Bucket
+	}{
+		{
+			name: "one bucket",
+			url:  "/query/counter/PollCount?from=1000&to=2000&fn=max,rate",
+			code: http.StatusOK,
+			want: []Bucket{{T: start, Count: 6, Values: map[string]float64{"max": 50, "rate": 1}}},
+		},
+		{
+			name: "step",
+			url:  "/query/counter/PollCount?from=1000&to=2000&step=30s&fn=min,last",
+			code: http.StatusOK,
+			want: []Bucket{
+				{T: start, Count: 3, Values: map[string]float64{"min": 0, "last": 20}},
+				{T: start.Add(30 * time.Second), Count: 3, Values: map[string]float64{"min": 30, "last": 50}},
+			},
+		},
+		{
+			name: "empty",
+			url:  "/query/counter/PollCount?from=0&to=10",
+			code: http.StatusOK,
+			want: []Bucket{},
+		},
+		{name: "wrong step", url: "/query/counter/PollCount?step=0", code: http.StatusBadRequest},
+		{name: "too many buckets", url: "/query/counter/PollCount?from=0&to=2000&step=1ms", code: http.StatusBadRequest},
+		{name: "rate of the gauge", url: "/query/gauge/PollCount?fn=rate", code: http.StatusBadRequest},
+		{name: "unknown", url: "/query/gauge/PollCount", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			require.Equal(t, tt.code, w.Code, w.Body.String())
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp queryResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			require.Len(t, resp.Buckets, len(tt.want))
+			for i, b := range tt.want {
+				assert.True(t, b.T.Equal(resp.Buckets[i].T), "bucket %d time %v", i, resp.Buckets[i].T)
+				assert.Equal(t, b.Count, resp.Buckets[i].Count)
+				assert.Equal(t, b.Values, resp.Buckets[i].Values)
+			}
+		})
+	}
+}
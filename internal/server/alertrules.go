@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRateWindow the window of rate() without [window]
+const defaultRateWindow = time.Minute
+
+// alertRule the threshold rule like "HeapAlloc > 500MB for 1m" or "rate(PollCount[30s]) == 0 for 30s"
+// the plain metric matches gauges and counters with the ID of all sources, rate() matches counters
+type alertRule struct {
+	expr      string
+	rate      bool
+	window    time.Duration // the window of rate()
+	id        string
+	op        string
+	threshold float64
+	forDur    time.Duration
+}
+
+var ruleRegexp = regexp.MustCompile(`^(?:rate\(([^\s()\[\]]+)(?:\[(\S+)\])?\)|([^\s()\[\]]+))\s*(>=|<=|==|!=|>|<)\s*(\S+)(?:\s+for\s+(\S+))?$`)
+
+// parseRule parse the rule expression
+func parseRule(expr string) (alertRule, error) {
+	expr = strings.TrimSpace(expr)
+	match := ruleRegexp.FindStringSubmatch(expr)
+	if match == nil {
+		return alertRule{}, fmt.Errorf("wrong rule %q, use like HeapAlloc > 500MB for 1m", expr)
+	}
+	r := alertRule{expr: expr, id: match[3], op: match[4]}
+	var err error
+	if match[1] != "" {
+		r.rate = true
+		r.id = match[1]
+		r.window = defaultRateWindow
+		if match[2] != "" {
+			if r.window, err = common.ParseDuration(match[2]); err != nil || r.window <= 0 {
+				return r, fmt.Errorf("rule %q: wrong rate window %q", expr, match[2])
+			}
+		}
+	}
+	if r.threshold, err = parseThreshold(match[5]); err != nil {
+		return r, fmt.Errorf("rule %q: %w", expr, err)
+	}
+	if match[6] != "" {
+		if r.forDur, err = common.ParseDuration(match[6]); err != nil || r.forDur < 0 {
+			return r, fmt.Errorf("rule %q: wrong duration %q", expr, match[6])
+		}
+	}
+	return r, nil
+}
+
+// thresholdUnits binary multipliers of byte units
+var thresholdUnits = []struct {
+	suffix string
+	mul    float64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"TB", 1 << 40},
+}
+
+// parseThreshold the number with the optional unit KB, MB, GB or TB
+func parseThreshold(s string) (float64, error) {
+	mul := 1.0
+	for _, u := range thresholdUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong threshold %q", s)
+	}
+	return v * mul, nil
+}
+
+// match true if the value satisfies the rule condition
+func (r *alertRule) match(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	case "!=":
+		return v != r.threshold
+	}
+	return false
+}
+
+// loadRules read rules from the file, one rule per line, empty lines and lines starting with # are skipped
+func loadRules(fileName string) ([]alertRule, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []alertRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", fileName, n, err)
+		}
+		rules = append(rules, r)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, errors.New(fileName + ": no rules")
+	}
+	return rules, nil
+}
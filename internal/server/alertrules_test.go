@@ -0,0 +1,66 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_parseRule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    alertRule
+		wantErr bool
+	}{
+		{
+			expr: "HeapAlloc > 500MB for 1m",
+			want: alertRule{id: "HeapAlloc", op: ">", threshold: 500 << 20, forDur: time.Minute},
+		},
+		{
+			expr: "rate(PollCount) == 0 for 30s",
+			want: alertRule{rate: true, window: defaultRateWindow, id: "PollCount", op: "==", threshold: 0, forDur: 30 * time.Second},
+		},
+		{
+			expr: "rate(PollCount[5m])<=0.5",
+			want: alertRule{rate: true, window: 5 * time.Minute, id: "PollCount", op: "<=", threshold: 0.5},
+		},
+		{
+			expr: "  CPUutilization1 >= 90  ",
+			want: alertRule{id: "CPUutilization1", op: ">=", threshold: 90},
+		},
+		{expr: "HeapAlloc >", wantErr: true},
+		{expr: "HeapAlloc => 1", wantErr: true},
+		{expr: "HeapAlloc > 5XB", wantErr: true},
+		{expr: "HeapAlloc > 1 for ever", wantErr: true},
+		{expr: "rate(PollCount[0s]) > 1", wantErr: true},
+		{expr: "avg(HeapAlloc) > 1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := parseRule(tt.expr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			got.expr = ""
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_loadRules(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "rules.txt")
+	require.NoError(t, os.WriteFile(fileName, []byte("# memory\nHeapAlloc > 500MB for 1m\n\nrate(PollCount) == 0 for 30s\n"), 0600))
+	rules, err := loadRules(fileName)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "rate(PollCount) == 0 for 30s", rules[1].expr)
+
+	require.NoError(t, os.WriteFile(fileName, []byte("HeapAlloc > 1\nwrong\n"), 0600))
+	_, err = loadRules(fileName)
+	assert.ErrorContains(t, err, "rules.txt:2")
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+
+	// resolvedRetention the time resolved alerts are listed
+	resolvedRetention = 15 * time.Minute
+	// webhookTimeout the timeout of the webhook request
+	webhookTimeout = 5 * time.Second
+	// webhookQueueSize notifications waiting for the webhook, new ones are dropped when it is full
+	webhookQueueSize = 100
+)
+
+// webhookRetryDelays pauses between attempts to post the notification
+var webhookRetryDelays = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
+// Alert the state of the rule for the metric
+type Alert struct {
+	Rule       string     `json:"rule"`
+	ID         string     `json:"id"`
+	MType      string     `json:"type"`
+	Source     string     `json:"source,omitempty"`
+	State      string     `json:"state"`
+	Value      float64    `json:"value"`
+	ActiveAt   time.Time  `json:"activeAt"`
+	FiredAt    *time.Time `json:"firedAt,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// alertKey the alert of the rule by index for the metric
+type alertKey struct {
+	rule int
+	seriesKey
+}
+
+// alerter evaluates rules periodically, firing and resolved alerts are queued for the webhook
+// and posted by the own goroutine, so a slow webhook does not delay evaluations
+type alerter struct {
+	rules    []alertRule
+	repo     common.Repository
+	history  *history
+	interval time.Duration
+	webhook  string // empty disables notifications
+	client   *http.Client
+	queue    chan Alert // notifications for the webhook
+
+	mu     sync.Mutex
+	alerts map[alertKey]*Alert
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newAlerter the constructor, rate() rules need the history
+func newAlerter(rules []alertRule, repo common.Repository, h *history, interval time.Duration, webhook string) (*alerter, error) {
+	for _, r := range rules {
+		if r.rate && h == nil {
+			return nil, fmt.Errorf("rule %q: rate() needs the history", r.expr)
+		}
+	}
+	return &alerter{
+		rules:    rules,
+		repo:     repo,
+		history:  h,
+		interval: interval,
+		webhook:  webhook,
+		client:   &http.Client{Timeout: webhookTimeout},
+		queue:    make(chan Alert, webhookQueueSize),
+		alerts:   make(map[alertKey]*Alert),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// start the evaluation and notification goroutines
+func (a *alerter) start() {
+	a.wg.Add(2)
+	go a.evaluateJob()
+	go a.notifyJob()
+}
+
+// stop the evaluation and notification goroutines, queued notifications are not sent
+func (a *alerter) stop() {
+	close(a.done)
+	a.wg.Wait()
+}
+
+// evaluateJob goroutine for periodic evaluation
+func (a *alerter) evaluateJob() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), a.interval)
+			if err := a.evaluate(ctx, time.Now()); err != nil {
+				log.Println("alerts:", err)
+			}
+			cancel()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// evaluate rules against stored metrics at now and notify about changed alerts
+func (a *alerter) evaluate(ctx context.Context, now time.Time) error {
+	list, err := a.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	var changed []Alert
+	a.mu.Lock()
+	for i := range a.rules {
+		changed = append(changed, a.evaluateRule(i, list, now)...)
+	}
+	for k, alert := range a.alerts {
+		if alert.State == AlertResolved && now.Sub(*alert.ResolvedAt) > resolvedRetention {
+			delete(a.alerts, k)
+		}
+	}
+	a.mu.Unlock()
+
+	for _, alert := range changed {
+		a.notify(alert)
+	}
+	return nil
+}
+
+// evaluateRule update alerts of the rule, returns copies of fired and resolved alerts
+// must be called under a.mu
+func (a *alerter) evaluateRule(i int, list []common.Metrics, now time.Time) []Alert {
+	r := &a.rules[i]
+	var changed []Alert
+	active := make(map[alertKey]bool)
+	for _, m := range list {
+		v, ok := a.value(r, m, now)
+		if !ok || !r.match(v) {
+			continue
+		}
+		k := alertKey{rule: i, seriesKey: seriesKey{mType: m.MType, Key: m.Key()}}
+		active[k] = true
+		alert, ok := a.alerts[k]
+		if !ok || alert.State == AlertResolved {
+			alert = &Alert{Rule: r.expr, ID: m.ID, MType: m.MType, Source: m.Source, State: AlertPending, ActiveAt: now}
+			a.alerts[k] = alert
+		}
+		alert.Value = v
+		if alert.State == AlertPending && now.Sub(alert.ActiveAt) >= r.forDur {
+			alert.State = AlertFiring
+			firedAt := now
+			alert.FiredAt = &firedAt
+			changed = append(changed, *alert)
+		}
+	}
+	for k, alert := range a.alerts {
+		if k.rule != i || active[k] {
+			continue
+		}
+		switch alert.State {
+		case AlertPending:
+			delete(a.alerts, k)
+		case AlertFiring:
+			alert.State = AlertResolved
+			resolvedAt := now
+			alert.ResolvedAt = &resolvedAt
+			changed = append(changed, *alert)
+		}
+	}
+	return changed
+}
+
+// value of the rule expression for the metric, false if the rule does not match the metric
+// rate of the counter without samples in the window is 0
+func (a *alerter) value(r *alertRule, m common.Metrics, now time.Time) (float64, bool) {
+	if m.ID != r.id {
+		return 0, false
+	}
+	if r.rate {
+		if m.MType != common.MTypeCounter {
+			return 0, false
+		}
+		samples, _ := a.history.query(m.MType, m.Key(), now.Add(-r.window), now)
+		v, ok := rate(samples)
+		if !ok {
+			return 0, true
+		}
+		return v, true
+	}
+	switch {
+	case m.MType == common.MTypeGauge && m.Value != nil:
+		return *m.Value, true
+	case m.MType == common.MTypeCounter && m.Delta != nil:
+		return float64(*m.Delta), true
+	}
+	return 0, false
+}
+
+// notify log the alert and queue it for the webhook
+func (a *alerter) notify(alert Alert) {
+	log.Printf("alert %s: %s %s %s = %v", alert.State, alert.Rule, alert.Source, alert.ID, alert.Value)
+	if a.webhook == "" {
+		return
+	}
+	select {
+	case a.queue <- alert:
+	default:
+		log.Printf("webhook queue is full, alert %s %s %s dropped", alert.State, alert.Source, alert.ID)
+	}
+}
+
+// notifyJob goroutine for posting queued notifications to the webhook
+func (a *alerter) notifyJob() {
+	defer a.wg.Done()
+	for {
+		select {
+		case alert := <-a.queue:
+			a.post(alert)
+		case <-a.done:
+			if n := len(a.queue); n != 0 {
+				log.Printf("webhook: %d notifications are not sent", n)
+			}
+			return
+		}
+	}
+}
+
+// post the alert to the webhook, every attempt has its own webhookTimeout,
+// network errors and 5xx responses are retried until attempts are over or the alerter is stopped
+func (a *alerter) post(alert Alert) {
+	b, err := json.Marshal(alert)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		retriable, err := a.postOnce(b)
+		if err == nil {
+			return
+		}
+		if !retriable || attempt >= len(webhookRetryDelays) {
+			log.Printf("webhook: alert %s %s %s is not sent: %v", alert.State, alert.Source, alert.ID, err)
+			return
+		}
+		log.Printf("webhook attempt %d: %v, retry in %v", attempt+1, err, webhookRetryDelays[attempt])
+		select {
+		case <-time.After(webhookRetryDelays[attempt]):
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// postOnce one request to the webhook, returns the error and true if it may be retried
+func (a *alerter) postOnce(b []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, a.webhook, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.StatusCode >= http.StatusInternalServerError, errors.New(resp.Status)
+	}
+	return false, nil
+}
+
+// list alerts sorted by the rule, the source and ID, all states if state is empty
+func (a *alerter) list(state string) []Alert {
+	a.mu.Lock()
+	list := make([]Alert, 0, len(a.alerts))
+	for _, alert := range a.alerts {
+		if state == "" || alert.State == state {
+			list = append(list, *alert)
+		}
+	}
+	a.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Rule != list[j].Rule {
+			return list[i].Rule < list[j].Rule
+		}
+		if list[i].Source != list[j].Source {
+			return list[i].Source < list[j].Source
+		}
+		return list[i].MType < list[j].MType
+	})
+	return list
+}
+
+// alertsHandler GET /alerts?state= alerts in JSON
+func (s *Server) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.alerts == nil {
+		http.Error(w, "alerting is disabled", http.StatusNotFound)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", AlertPending, AlertFiring, AlertResolved:
+	default:
+		http.Error(w, fmt.Sprintf("unknown state %q", state), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, s.alerts.list(state))
+}
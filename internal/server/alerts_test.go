@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAlerter_evaluate(t *testing.T) {
+	var mu sync.Mutex
+	var posted []Alert
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		mu.Lock()
+		posted = append(posted, alert)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	ctx := context.Background()
+	repo := common.NewMemRepository()
+	h := newHistory(time.Hour, time.Second)
+	var rules []alertRule
+	for _, expr := range []string{"HeapAlloc > 1KB for 20s", "rate(PollCount[30s]) == 0 for 10s"} {
+		r, err := parseRule(expr)
+		require.NoError(t, err)
+		rules = append(rules, r)
+	}
+	a, err := newAlerter(rules, repo, h, time.Second, hook.URL)
+	require.NoError(t, err)
+	// notifications only, rules are evaluated by the test
+	a.wg.Add(1)
+	go a.notifyJob()
+	defer a.stop()
+
+	update := func(m common.Metrics, now time.Time) {
+		require.NoError(t, repo.Update(ctx, &m))
+		h.add([]common.Metrics{m}, now)
+	}
+	states := func() map[string]string {
+		got := make(map[string]string)
+		for _, alert := range a.list("") {
+			got[alert.Source+"/"+alert.ID] = alert.State
+		}
+		return got
+	}
+	host1 := func(m common.Metrics) common.Metrics {
+		m.Source = "host-1"
+		return m
+	}
+
+	start := time.Unix(1000, 0)
+	update(host1(common.NewGauge("HeapAlloc", 2048)), start)
+	update(common.NewGauge("HeapAlloc", 10), start)
+	update(host1(common.NewCounter("PollCount", 1)), start)
+	require.NoError(t, a.evaluate(ctx, start))
+	assert.Equal(t, map[string]string{"host-1/HeapAlloc": AlertPending, "host-1/PollCount": AlertPending}, states())
+
+	// the counter grows, the pending alert is dropped
+	update(host1(common.NewCounter("PollCount", 1)), start.Add(10*time.Second))
+	require.NoError(t, a.evaluate(ctx, start.Add(10*time.Second)))
+	assert.Equal(t, map[string]string{"host-1/HeapAlloc": AlertPending}, states())
+
+	require.NoError(t, a.evaluate(ctx, start.Add(20*time.Second)))
+	assert.Equal(t, map[string]string{"host-1/HeapAlloc": AlertFiring}, states())
+
+	// the agent has stopped, no samples in the rate window
+	update(host1(common.NewGauge("HeapAlloc", 512)), start.Add(45*time.Second))
+	require.NoError(t, a.evaluate(ctx, start.Add(45*time.Second)))
+	assert.Equal(t, map[string]string{"host-1/HeapAlloc": AlertResolved, "host-1/PollCount": AlertPending}, states())
+	require.NoError(t, a.evaluate(ctx, start.Add(55*time.Second)))
+	assert.Equal(t, map[string]string{"host-1/HeapAlloc": AlertResolved, "host-1/PollCount": AlertFiring}, states())
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(posted) == 3
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, AlertFiring, posted[0].State)
+	assert.Equal(t, "HeapAlloc > 1KB for 20s", posted[0].Rule)
+	assert.Equal(t, 2048.0, posted[0].Value)
+	assert.Equal(t, AlertResolved, posted[1].State)
+	assert.NotNil(t, posted[1].ResolvedAt)
+	assert.Equal(t, AlertFiring, posted[2].State)
+	assert.Equal(t, "PollCount", posted[2].ID)
+}
+
+func TestAlerter_notifyRetry(t *testing.T) {
+	saved := webhookRetryDelays
+	webhookRetryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+	defer func() { webhookRetryDelays = saved }()
+
+	var mu sync.Mutex
+	var attempts []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alert))
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, alert.ID)
+		switch {
+		case alert.ID == "Bad":
+			w.WriteHeader(http.StatusBadRequest)
+		case len(attempts) == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer hook.Close()
+
+	a, err := newAlerter(nil, common.NewMemRepository(), nil, time.Second, hook.URL)
+	require.NoError(t, err)
+	a.wg.Add(1)
+	go a.notifyJob()
+	defer a.stop()
+
+	// 5xx is retried, 4xx is not
+	for _, id := range []string{"Alloc", "Bad", "HeapAlloc"} {
+		a.notify(Alert{ID: id, State: AlertFiring})
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) == 4
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"Alloc", "Alloc", "Bad", "HeapAlloc"}, attempts)
+}
+
+func TestNewAlerter_rateWithoutHistory(t *testing.T) {
+	r, err := parseRule("rate(PollCount) > 1")
+	require.NoError(t, err)
+	_, err = newAlerter([]alertRule{r}, common.NewMemRepository(), nil, time.Second, "")
+	assert.Error(t, err)
+}
+
+func TestAlertsHandler(t *testing.T) {
+	s := &Server{repo: common.NewMemRepository()}
+	router := mux.NewRouter()
+	s.setHandlers(router)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/alerts", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	r, err := parseRule("Alloc > 1")
+	require.NoError(t, err)
+	s.alerts, err = newAlerter([]alertRule{r}, s.repo, nil, time.Second, "")
+	require.NoError(t, err)
+	m := common.NewGauge("Alloc", 2)
+	require.NoError(t, s.repo.Update(context.Background(), &m))
+	require.NoError(t, s.alerts.evaluate(context.Background(), time.Now()))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/alerts?state=firing", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var alerts []Alert
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&alerts))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "Alloc > 1", alerts[0].Rule)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/alerts?state=unknown", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"log"
+	"sync"
+)
+
+// batchIDHeader the id of the agent report, retries and replays of the report keep it,
+// the gRPC metadata key is the lower case
+const batchIDHeader = "X-Batch-ID"
+
+// maxBatchIDs how many ids of applied batches are remembered
+const maxBatchIDs = 10000
+
+// errBatchInProgress the batch with the same id is being applied, the client must retry later
+var errBatchInProgress = errors.New("batch is in progress")
+
+// batchState the state of the batch id
+type batchState int
+
+const (
+	batchNew        batchState = iota // the id is unknown, begin marks it in progress
+	batchInProgress                   // the batch with the id is being applied
+	batchApplied                      // the batch with the id is committed
+)
+
+// batchIDs ids of batches in progress and of recently applied ones, so the retried batch is not applied twice
+// ids are kept in the memory, the oldest applied id is forgotten when maxBatchIDs is reached
+type batchIDs struct {
+	mu      sync.Mutex
+	pending map[string]bool // ids of batches being applied
+	slots   map[string]int  // the index in order by the applied id
+	order   []string        // the ring of applied ids
+	next    int
+}
+
+// begin returns the state of the id, the new id is marked in progress and must be passed to finish
+func (b *batchIDs) begin(id string) batchState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == nil {
+		b.pending = make(map[string]bool)
+		b.slots = make(map[string]int)
+		b.order = make([]string, maxBatchIDs)
+	}
+	if b.pending[id] {
+		return batchInProgress
+	}
+	if _, ok := b.slots[id]; ok {
+		return batchApplied
+	}
+	b.pending[id] = true
+	return batchNew
+}
+
+// finish the batch begun, the id is remembered as applied if the batch is committed,
+// otherwise it is forgotten and the batch may be sent again
+func (b *batchIDs) finish(id string, applied bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.pending, id)
+	if !applied {
+		return
+	}
+	if old := b.order[b.next]; old != "" {
+		delete(b.slots, old)
+	}
+	b.order[b.next] = id
+	b.slots[id] = b.next
+	b.next = (b.next + 1) % len(b.order)
+}
+
+// updateBatchOnce updateBatch, the batch with the id applied already is skipped,
+// errBatchInProgress if the batch with the id is being applied, the empty id is not checked
+func (s *Server) updateBatchOnce(ctx context.Context, batch string, list []common.Metrics) ([]batchError, error) {
+	if batch == "" {
+		return s.updateBatch(ctx, list)
+	}
+	switch s.batches.begin(batch) {
+	case batchInProgress:
+		return nil, fmt.Errorf("%w: %s", errBatchInProgress, batch)
+	case batchApplied:
+		log.Printf("batch %s is applied already, skipped", batch)
+		return nil, nil
+	}
+	errs, err := s.updateBatch(ctx, list)
+	s.batches.finish(batch, err == nil && len(errs) == 0)
+	return errs, err
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	pb "github.com/S0me0neR0man/yayaops/internal/proto"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBatchIDs(t *testing.T) {
+	var b batchIDs
+	assert.Equal(t, batchNew, b.begin("a-1"))
+	assert.Equal(t, batchInProgress, b.begin("a-1"), "the id in progress")
+	b.finish("a-1", false)
+	assert.Equal(t, batchNew, b.begin("a-1"), "the failed id")
+	b.finish("a-1", true)
+	assert.Equal(t, batchApplied, b.begin("a-1"), "the applied id")
+
+	// the oldest id is forgotten
+	for i := 0; i < maxBatchIDs; i++ {
+		id := fmt.Sprintf("b-%d", i)
+		require.Equal(t, batchNew, b.begin(id))
+		b.finish(id, true)
+	}
+	assert.Equal(t, batchNew, b.begin("a-1"))
+	assert.Equal(t, batchApplied, b.begin(fmt.Sprintf("b-%d", maxBatchIDs-1)))
+}
+
+// slowFailingRepo the first UpdateBatch waits for release and fails, others are applied
+type slowFailingRepo struct {
+	common.Repository
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *slowFailingRepo) UpdateBatch(ctx context.Context, list []common.Metrics) error {
+	if atomic.AddInt32(&r.calls, 1) == 1 {
+		close(r.started)
+		<-r.release
+		return errors.New("database is down")
+	}
+	return r.Repository.UpdateBatch(ctx, list)
+}
+
+func TestUpdatesJSONHandler_BatchInProgress(t *testing.T) {
+	repo := &slowFailingRepo{Repository: common.NewMemRepository(), started: make(chan struct{}), release: make(chan struct{})}
+	s := &Server{repo: repo}
+	router := mux.NewRouter()
+	s.setHandlers(router)
+	post := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(`[{"id":"PollCount","type":"counter","delta":3}]`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(batchIDHeader, "run-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	first := make(chan int)
+	go func() {
+		first <- post()
+	}()
+	<-repo.started
+	assert.Equal(t, http.StatusServiceUnavailable, post(), "the duplicate in flight is retried")
+	close(repo.release)
+	assert.Equal(t, http.StatusInternalServerError, <-first)
+	assert.Equal(t, http.StatusOK, post(), "the retry after the failure is applied")
+	assert.Equal(t, http.StatusOK, post(), "the applied batch is skipped")
+
+	m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+	require.NoError(t, s.repo.Get(context.Background(), &m))
+	assert.Equal(t, int64(3), *m.Delta)
+}
+
+func TestGRPCService_UpdatesBatchInProgress(t *testing.T) {
+	s := &Server{repo: common.NewMemRepository()}
+	require.Equal(t, batchNew, s.batches.begin("run-1"))
+	client := newTestGRPCClient(t, s)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), batchIDHeader, "run-1")
+	req := &pb.UpdatesRequest{Metrics: []*pb.Metric{pb.FromMetrics(common.NewCounter("PollCount", 3))}}
+	_, err := client.Updates(ctx, req)
+	assert.Equal(t, codes.Aborted, status.Code(err), err)
+}
+
+func TestUpdatesJSONHandler_BatchID(t *testing.T) {
+	tests := []struct {
+		name      string
+		batches   []string
+		wantDelta int64
+	}{
+		{name: "retried batch", batches: []string{"run-1", "run-1"}, wantDelta: 3},
+		{name: "different batches", batches: []string{"run-1", "run-2"}, wantDelta: 6},
+		{name: "without id", batches: []string{"", ""}, wantDelta: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{repo: common.NewMemRepository()}
+			router := mux.NewRouter()
+			s.setHandlers(router)
+			for _, batch := range tt.batches {
+				r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(`[{"id":"PollCount","type":"counter","delta":3}]`))
+				r.Header.Set("Content-Type", "application/json")
+				if batch != "" {
+					r.Header.Set(batchIDHeader, batch)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+				require.Equal(t, http.StatusOK, w.Code)
+			}
+			m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+			require.NoError(t, s.repo.Get(context.Background(), &m))
+			assert.Equal(t, tt.wantDelta, *m.Delta)
+		})
+	}
+}
+
+func TestUpdatesJSONHandler_RejectedBatchID(t *testing.T) {
+	s := &Server{repo: common.NewMemRepository()}
+	router := mux.NewRouter()
+	s.setHandlers(router)
+	for _, tt := range []struct {
+		body     string
+		wantCode int
+	}{
+		{body: `[{"id":"PollCount","type":"counter"}]`, wantCode: http.StatusBadRequest},
+		{body: `[{"id":"PollCount","type":"counter","delta":3}]`, wantCode: http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(batchIDHeader, "run-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, tt.wantCode, w.Code, "the id of the rejected batch is forgotten")
+	}
+	m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+	require.NoError(t, s.repo.Get(context.Background(), &m))
+	assert.Equal(t, int64(3), *m.Delta)
+}
+
+func TestGRPCService_UpdatesBatchID(t *testing.T) {
+	s := &Server{repo: common.NewMemRepository()}
+	client := newTestGRPCClient(t, s)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), batchIDHeader, "run-1")
+	req := &pb.UpdatesRequest{Metrics: []*pb.Metric{pb.FromMetrics(common.NewCounter("PollCount", 3))}}
+	for i := 0; i < 2; i++ {
+		_, err := client.Updates(ctx, req)
+		require.NoError(t, err)
+	}
+	m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+	require.NoError(t, s.repo.Get(context.Background(), &m))
+	assert.Equal(t, int64(3), *m.Delta)
+}
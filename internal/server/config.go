@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"net"
+	"time"
+)
+
+// Config the server configuration
+type Config struct {
+	Addr          string        // ADDRESS, -a
+	StoreInterval time.Duration // STORE_INTERVAL, -i, 0 is the synchronous saving
+	StoreFile     string        // STORE_FILE, -f, empty disables the saving
+	Restore       bool          // RESTORE, -r
+	Key           string        // KEY, -k
+	DatabaseDSN   string        // DATABASE_DSN, -d
+	GRPCAddr      string        // GRPC_ADDRESS, -g, empty disables the gRPC service
+	CryptoKey     string        // CRYPTO_KEY, -c, the PEM file of the RSA private key to decrypt requests
+
+	TLSCert     string // TLS_CERT, -tls-cert, the PEM file of the certificate, empty disables TLS
+	TLSKey      string // TLS_KEY, -tls-key, the PEM file of the key of the certificate
+	TLSClientCA string // TLS_CLIENT_CA, -tls-client-ca, clients must present certificates signed by the CA if it is set
+
+	TrustedSubnet string // TRUSTED_SUBNET, -trusted-subnet, the CIDR of agents allowed to update metrics, empty allows all
+
+	HistoryRetention  time.Duration // HISTORY_RETENTION, -t, the history window, 0 disables the history
+	HistoryResolution time.Duration // HISTORY_RESOLUTION, -s, the interval between samples of the history
+
+	AlertRules    string        // ALERT_RULES, -e, the file of alert rules, empty disables alerting
+	AlertInterval time.Duration // ALERT_INTERVAL, -v, the interval of rules evaluation
+	AlertWebhook  string        // ALERT_WEBHOOK, -u, the URL to post firing and resolved alerts
+
+	StaleAfter time.Duration // STALE_AFTER, -n, metrics not updated within it are stale, 0 disables
+	MetricTTL  time.Duration // METRIC_TTL, -x, metrics not updated within it are deleted, 0 disables
+}
+
+// NewConfig parse command line arguments without the program name,
+// environment variables take precedence over flags
+func NewConfig(name string, args []string) (Config, error) {
+	cfg := Config{}
+	cfg.StoreInterval = 300 * time.Second
+	cfg.HistoryRetention = time.Hour
+	cfg.HistoryResolution = 10 * time.Second
+	cfg.AlertInterval = 10 * time.Second
+	cfg.StaleAfter = time.Minute
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.Addr, "a", "127.0.0.1:8080", "address to listen `host:port`")
+	fs.Var(common.DurationValue{D: &cfg.StoreInterval}, "i", "store `interval` like 300s, 0 saves every update")
+	fs.StringVar(&cfg.StoreFile, "f", "/tmp/devops-metrics-db.json", "store `file`, empty disables the saving")
+	fs.BoolVar(&cfg.Restore, "r", true, "restore metrics from the store file on start")
+	fs.StringVar(&cfg.Key, "k", "", "the `key` of the metrics hash")
+	fs.StringVar(&cfg.DatabaseDSN, "d", "", "PostgreSQL `dsn`, the store file is not used if it is set")
+	fs.StringVar(&cfg.GRPCAddr, "g", "", "address of the gRPC service `host:port`, empty disables it")
+	fs.StringVar(&cfg.CryptoKey, "c", "", "the PEM `file` of the RSA private key to decrypt requests")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "the PEM `file` of the certificate, HTTP and gRPC are served by TLS if it is set")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "the PEM `file` of the key of the certificate")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", "", "the PEM `file` of CA certificates to verify client certificates")
+	fs.StringVar(&cfg.TrustedSubnet, "trusted-subnet", "", "the `cidr` of agents allowed to update metrics by X-Real-IP, empty allows all")
+	fs.Var(common.DurationValue{D: &cfg.HistoryRetention}, "t", "history `window` like 1h, 0 disables the history")
+	fs.Var(common.DurationValue{D: &cfg.HistoryResolution}, "s", "history `resolution` like 10s")
+	fs.StringVar(&cfg.AlertRules, "e", "", "alert rules `file`, one rule like 'HeapAlloc > 500MB for 1m' per line")
+	fs.Var(common.DurationValue{D: &cfg.AlertInterval}, "v", "alert rules evaluation `interval` like 10s")
+	fs.StringVar(&cfg.AlertWebhook, "u", "", "the webhook `url` to post alerts")
+	fs.Var(common.DurationValue{D: &cfg.StaleAfter}, "n", "metrics not updated within the `interval` are stale, 0 disables")
+	fs.Var(common.DurationValue{D: &cfg.MetricTTL}, "x", "metrics not updated within the `ttl` are deleted, 0 disables")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	common.EnvString("ADDRESS", &cfg.Addr)
+	common.EnvString("STORE_FILE", &cfg.StoreFile)
+	common.EnvString("KEY", &cfg.Key)
+	common.EnvString("DATABASE_DSN", &cfg.DatabaseDSN)
+	common.EnvString("GRPC_ADDRESS", &cfg.GRPCAddr)
+	common.EnvString("CRYPTO_KEY", &cfg.CryptoKey)
+	common.EnvString("TLS_CERT", &cfg.TLSCert)
+	common.EnvString("TLS_KEY", &cfg.TLSKey)
+	common.EnvString("TLS_CLIENT_CA", &cfg.TLSClientCA)
+	common.EnvString("TRUSTED_SUBNET", &cfg.TrustedSubnet)
+	common.EnvString("ALERT_RULES", &cfg.AlertRules)
+	common.EnvString("ALERT_WEBHOOK", &cfg.AlertWebhook)
+	if err := common.EnvDuration("STORE_INTERVAL", &cfg.StoreInterval); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvBool("RESTORE", &cfg.Restore); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvDuration("HISTORY_RETENTION", &cfg.HistoryRetention); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvDuration("HISTORY_RESOLUTION", &cfg.HistoryResolution); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvDuration("ALERT_INTERVAL", &cfg.AlertInterval); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvDuration("STALE_AFTER", &cfg.StaleAfter); err != nil {
+		return cfg, err
+	}
+	if err := common.EnvDuration("METRIC_TTL", &cfg.MetricTTL); err != nil {
+		return cfg, err
+	}
+
+	if cfg.Addr == "" {
+		return cfg, errors.New("empty address")
+	}
+	if cfg.GRPCAddr != "" && cfg.GRPCAddr == cfg.Addr {
+		return cfg, fmt.Errorf("gRPC and HTTP addresses are the same %s", cfg.Addr)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, errors.New("the TLS certificate and the key must be set together")
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		return cfg, errors.New("client certificates are verified by TLS only, set the certificate")
+	}
+	if cfg.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(cfg.TrustedSubnet); err != nil {
+			return cfg, fmt.Errorf("trusted subnet: %w", err)
+		}
+	}
+	if cfg.StoreInterval < 0 {
+		return cfg, fmt.Errorf("negative store interval %v", cfg.StoreInterval)
+	}
+	if cfg.HistoryRetention < 0 {
+		return cfg, fmt.Errorf("negative history retention %v", cfg.HistoryRetention)
+	}
+	if cfg.HistoryRetention > 0 && cfg.HistoryResolution <= 0 {
+		return cfg, fmt.Errorf("history resolution must be positive, got %v", cfg.HistoryResolution)
+	}
+	if cfg.AlertRules != "" && cfg.AlertInterval <= 0 {
+		return cfg, fmt.Errorf("alert interval must be positive, got %v", cfg.AlertInterval)
+	}
+	if cfg.StaleAfter < 0 {
+		return cfg, fmt.Errorf("negative stale interval %v", cfg.StaleAfter)
+	}
+	if cfg.MetricTTL < 0 {
+		return cfg, fmt.Errorf("negative metric ttl %v", cfg.MetricTTL)
+	}
+	return cfg, nil
+}
+
+// String hides secrets
+func (c Config) String() string {
+	type plain Config
+	p := plain(c)
+	if p.Key != "" {
+		p.Key = "***"
+	}
+	if p.DatabaseDSN != "" {
+		p.DatabaseDSN = "***"
+	}
+	// webhook URLs often contain tokens
+	if p.AlertWebhook != "" {
+		p.AlertWebhook = "***"
+	}
+	return fmt.Sprintf("%+v", p)
+}
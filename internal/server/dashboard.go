@@ -0,0 +1,139 @@
+package server
+
+import (
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// dashboardRefresh the page reload interval in seconds
+const dashboardRefresh = 10
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Metrics</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+td.value { text-align: right; font-family: monospace; }
+tr.stale { color: #999; }
+</style>
+</head>
+<body>
+<h1>Metrics{{if .Source}} of {{.Source}}{{end}}</h1>
+{{- if .Sources}}
+<p>Sources: <a href="/">all</a>
+{{- range .Sources}} <a href="/?source={{.}}">{{.}}</a>{{end}}</p>
+{{- end}}
+{{- range .Groups}}
+<h2>{{.MType}} ({{len .Rows}})</h2>
+<table>
+<tr><th>Source</th><th>Name</th><th>Value</th><th>Updated</th></tr>
+{{- range .Rows}}
+<tr{{if .Stale}} class="stale"{{end}}><td>{{.Source}}</td><td>{{.ID}}</td><td class="value">{{.StrValue}}</td><td>{{.Age}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No metrics</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// dashboardGroup metrics of the same type
+type dashboardGroup struct {
+	MType string
+	Rows  []dashboardRow
+}
+
+// dashboardRow the metric with the time since the last update
+type dashboardRow struct {
+	common.Metrics
+	Age   string
+	Stale bool
+}
+
+// dashboardHandler GET / the HTML page with all metrics grouped by type
+// the 'source' query parameter filters metrics of the source
+func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := s.repo.List(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sources := make(map[string]bool)
+	for _, m := range list {
+		if m.Source != "" {
+			sources[m.Source] = true
+		}
+	}
+	source, filtered := r.URL.Query()["source"]
+	if filtered {
+		list = filterSource(list, source[0])
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ID != list[j].ID {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Source < list[j].Source
+	})
+
+	now := time.Now()
+	var groups []dashboardGroup
+	for _, mType := range []string{common.MTypeGauge, common.MTypeCounter} {
+		g := dashboardGroup{MType: mType}
+		for _, m := range list {
+			if m.MType != mType {
+				continue
+			}
+			row := dashboardRow{Metrics: m, Stale: s.stale(&m, now)}
+			if m.Updated != nil {
+				row.Age = now.Sub(*m.Updated).Truncate(time.Second).String() + " ago"
+			}
+			g.Rows = append(g.Rows, row)
+		}
+		if len(g.Rows) != 0 {
+			groups = append(groups, g)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := struct {
+		Refresh int
+		Source  string
+		Sources []string
+		Groups  []dashboardGroup
+	}{
+		Refresh: dashboardRefresh,
+		Groups:  groups,
+	}
+	if filtered {
+		data.Source = source[0]
+	}
+	for name := range sources {
+		data.Sources = append(data.Sources, name)
+	}
+	sort.Strings(data.Sources)
+	if err = dashboardTemplate.Execute(w, data); err != nil {
+		log.Println(err)
+	}
+}
+
+// filterSource metrics of the source
+func filterSource(list []common.Metrics, source string) []common.Metrics {
+	filtered := list[:0]
+	for _, m := range list {
+		if m.Source == source {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{repo: common.NewMemRepository()}
+	value := 2.5
+	delta := int64(3)
+	for _, m := range []common.Metrics{
+		{ID: "HeapAlloc", MType: common.MTypeGauge, Value: &value},
+		{ID: "Alloc", MType: common.MTypeGauge, Value: &value},
+		{ID: "PollCount", MType: common.MTypeCounter, Delta: &delta},
+	} {
+		m := m
+		require.NoError(t, s.repo.Update(ctx, &m))
+	}
+
+	router := mux.NewRouter()
+	s.setHandlers(router)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, "<tr><td></td><td>PollCount</td><td class=\"value\">3</td><td>0s ago</td></tr>")
+	// sorted by name, gauges first
+	alloc := strings.Index(body, ">Alloc<")
+	heapAlloc := strings.Index(body, ">HeapAlloc<")
+	pollCount := strings.Index(body, ">PollCount<")
+	assert.True(t, alloc < heapAlloc && heapAlloc < pollCount, body)
+}
+
+func TestDashboardHandler_Source(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{repo: common.NewMemRepository()}
+	for _, source := range []string{"host-1", "host 2"} {
+		m := common.NewGauge("Alloc", 1)
+		m.Source = source
+		require.NoError(t, s.repo.Update(ctx, &m))
+	}
+
+	router := mux.NewRouter()
+	s.setHandlers(router)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?source=host+2", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "<h1>Metrics of host 2</h1>")
+	assert.Contains(t, body, `<a href="/?source=host-1">host-1</a>`)
+	assert.Contains(t, body, "<tr><td>host 2</td><td>Alloc</td>")
+	assert.NotContains(t, body, "<tr><td>host-1</td>")
+}
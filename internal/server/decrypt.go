@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"github.com/S0me0neR0man/yayaops/internal/envelope"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// decrypting middleware
+// decrypt the request with the envelope.Header by the private key of the server,
+// the decrypted body may be compressed, so it must run before gzipping
+func (s *Server) decrypting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := r.Header.Get(envelope.Header)
+		if scheme == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if scheme != envelope.Scheme {
+			http.Error(w, "unsupported encryption "+scheme, http.StatusBadRequest)
+			return
+		}
+		if s.privateKey == nil {
+			http.Error(w, "encryption is not configured", http.StatusBadRequest)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			b, err = envelope.Open(s.privateKey, b)
+		}
+		if err != nil {
+			log.Println("decrypt:", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+		r.ContentLength = int64(len(b))
+		r.Header.Del(envelope.Header)
+		next.ServeHTTP(w, r)
+	})
+}
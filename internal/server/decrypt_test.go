@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/S0me0neR0man/yayaops/internal/envelope"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecrypting(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":3}]`)
+	sealed, err := envelope.Seal(&priv.PublicKey, body)
+	require.NoError(t, err)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err = gz.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	sealedGzip, err := envelope.Seal(&priv.PublicKey, gzipped.Bytes())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		key      *rsa.PrivateKey
+		body     []byte
+		scheme   string
+		gzip     bool
+		wantCode int
+	}{
+		{name: "plain", key: priv, body: body, wantCode: http.StatusOK},
+		{name: "encrypted", key: priv, body: sealed, scheme: envelope.Scheme, wantCode: http.StatusOK},
+		{name: "encrypted gzip", key: priv, body: sealedGzip, scheme: envelope.Scheme, gzip: true, wantCode: http.StatusOK},
+		{name: "unknown scheme", key: priv, body: sealed, scheme: "rot13", wantCode: http.StatusBadRequest},
+		{name: "without key", body: sealed, scheme: envelope.Scheme, wantCode: http.StatusBadRequest},
+		{name: "not encrypted", key: priv, body: body, scheme: envelope.Scheme, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{repo: common.NewMemRepository(), privateKey: tt.key}
+			router := mux.NewRouter()
+			s.setHandlers(router)
+
+			r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.scheme != "" {
+				r.Header.Set(envelope.Header, tt.scheme)
+			}
+			if tt.gzip {
+				r.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+			require.NoError(t, s.repo.Get(context.Background(), &m))
+			assert.Equal(t, int64(3), *m.Delta)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileStore keeps the storage snapshot in the JSON file
+type fileStore struct {
+	repo     common.Repository
+	fileName string
+	interval time.Duration
+	mu       sync.Mutex // serializes snapshots and file writes
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newFileStore(repo common.Repository, fileName string, interval time.Duration) *fileStore {
+	return &fileStore{
+		repo:     repo,
+		fileName: fileName,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// synchronous true if every update must be saved immediately
+func (f *fileStore) synchronous() bool {
+	return f.interval == 0
+}
+
+// start the periodic flush goroutine, does nothing in synchronous mode
+func (f *fileStore) start() {
+	if f.synchronous() {
+		return
+	}
+	f.wg.Add(1)
+	go f.flushJob()
+}
+
+// stop the flush goroutine and save the storage last time
+func (f *fileStore) stop() error {
+	close(f.done)
+	f.wg.Wait()
+	return f.save()
+}
+
+// flushJob goroutine for periodic save
+func (f *fileStore) flushJob() {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.save(); err != nil {
+				log.Println(err)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// save write all metrics to the file
+// the snapshot is taken under f.mu, so the older snapshot never overwrites the newer one
+func (f *fileStore) save() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list, err := f.repo.List(context.Background())
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	// write to the temporary file first, so the crash does not leave a broken snapshot
+	tmp := f.fileName + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.fileName)
+}
+
+// restore load metrics from the file, the missing file is not an error
+func (f *fileStore) restore() error {
+	b, err := os.ReadFile(f.fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var list []common.Metrics
+	if err = json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	for i := range list {
+		if err = f.repo.Update(context.Background(), &list[i]); err != nil {
+			log.Printf("fileStore.restore() skip metric %+v: %v", list[i], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStore_SaveRestore(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+
+	value := 100.5
+	delta := int64(7)
+	src := common.NewMemRepository()
+	require.NoError(t, src.Update(ctx, &common.Metrics{ID: "Alloc", MType: common.MTypeGauge, Value: &value}))
+	require.NoError(t, src.Update(ctx, &common.Metrics{ID: "PollCount", MType: common.MTypeCounter, Delta: &delta}))
+	require.NoError(t, newFileStore(src, fileName, 0).save())
+
+	dst := common.NewMemRepository()
+	require.NoError(t, newFileStore(dst, fileName, 0).restore())
+
+	m := common.Metrics{ID: "Alloc", MType: common.MTypeGauge}
+	require.NoError(t, dst.Get(ctx, &m))
+	assert.Equal(t, 100.5, *m.Value)
+	m = common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+	require.NoError(t, dst.Get(ctx, &m))
+	assert.Equal(t, int64(7), *m.Delta)
+}
+
+func TestFileStore_ConcurrentSave(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+	repo := common.NewMemRepository()
+	store := newFileStore(repo, fileName, 0)
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := common.NewCounter("PollCount", 1)
+			assert.NoError(t, repo.Update(ctx, &m))
+			assert.NoError(t, store.save())
+		}()
+	}
+	wg.Wait()
+
+	// the last update is not lost
+	dst := common.NewMemRepository()
+	require.NoError(t, newFileStore(dst, fileName, 0).restore())
+	m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+	require.NoError(t, dst.Get(ctx, &m))
+	assert.Equal(t, int64(n), *m.Delta)
+}
+
+func TestFileStore_RestoreMissingFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "none.json")
+	assert.NoError(t, newFileStore(common.NewMemRepository(), fileName, 0).restore())
+}
+
+func TestServer_ShutdownSaves(t *testing.T) {
+	ctx := context.Background()
+	fileName := filepath.Join(t.TempDir(), "metrics.json")
+	s, err := New(Config{Addr: "127.0.0.1:0", StoreFile: fileName, StoreInterval: time.Hour})
+	require.NoError(t, err)
+	s.store.start()
+
+	delta := int64(3)
+	require.NoError(t, s.repo.Update(ctx, &common.Metrics{ID: "PollCount", MType: common.MTypeCounter, Delta: &delta}))
+	require.NoError(t, s.Shutdown(ctx))
+
+	repo := common.NewMemRepository()
+	require.NoError(t, newFileStore(repo, fileName, 0).restore())
+	m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+	require.NoError(t, repo.Get(ctx, &m))
+	assert.Equal(t, delta, *m.Delta)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	pb "github.com/S0me0neR0man/yayaops/internal/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // agents compress requests
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// grpcService the gRPC service of metrics,
+// updates are validated and stored like the HTTP handlers do
+type grpcService struct {
+	pb.UnimplementedMetricsServer
+	s *Server
+}
+
+// newGRPCServer the gRPC server with the metrics service, TLS is used if it is configured
+func newGRPCServer(s *Server) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.loggingUnary, s.trustedUnary),
+		grpc.ChainStreamInterceptor(s.loggingStream, s.trustedStream),
+	}
+	if s.tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.tlsConfig)))
+	}
+	g := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(g, &grpcService{s: s})
+	return g
+}
+
+// Update the metric like POST /update/
+func (g *grpcService) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateResponse, error) {
+	if err := g.update(ctx, req.GetMetric()); err != nil {
+		return nil, err
+	}
+	return &pb.UpdateResponse{}, nil
+}
+
+// Updates metrics like POST /updates/, errors of wrong items are in details of the status
+// the batch with x-batch-id of the metadata applied already is skipped, Aborted if it is in progress
+func (g *grpcService) Updates(ctx context.Context, req *pb.UpdatesRequest) (*pb.UpdatesResponse, error) {
+	list := make([]common.Metrics, len(req.GetMetrics()))
+	for i, x := range req.GetMetrics() {
+		list[i] = x.Metrics()
+	}
+	errs, err := g.s.updateBatchOnce(ctx, incoming(ctx, batchIDHeader), list)
+	if errors.Is(err, errBatchInProgress) {
+		log.Println(err)
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(errs) != 0 {
+		return nil, batchStatus(errs).Err()
+	}
+	return &pb.UpdatesResponse{}, nil
+}
+
+// UpdateStream every message is applied like Update
+func (g *grpcService) UpdateStream(stream pb.Metrics_UpdateStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.UpdatesResponse{})
+		}
+		if err != nil {
+			return err
+		}
+		if err = g.update(stream.Context(), req.GetMetric()); err != nil {
+			return err
+		}
+	}
+}
+
+// Value the metric like POST /value/
+func (g *grpcService) Value(ctx context.Context, req *pb.ValueRequest) (*pb.ValueResponse, error) {
+	m := req.GetMetric().Metrics()
+	m.Delta, m.Value, m.Hash = nil, nil, ""
+	if code, err := g.s.value(ctx, &m); err != nil {
+		log.Println(m.ID, err)
+		return nil, status.Error(grpcCode(code), err.Error())
+	}
+	return &pb.ValueResponse{Metric: pb.FromMetrics(m), Stale: g.s.stale(&m, time.Now())}, nil
+}
+
+// update the metric of the message, returns the status error
+func (g *grpcService) update(ctx context.Context, x *pb.Metric) error {
+	if x == nil {
+		return status.Error(codes.InvalidArgument, "empty metric")
+	}
+	m := x.Metrics()
+	if code, err := g.s.update(ctx, &m); err != nil {
+		log.Println(err)
+		return status.Error(grpcCode(code), err.Error())
+	}
+	return nil
+}
+
+// batchStatus the status of the wrong batch, items are field violations
+func batchStatus(errs []batchError) *status.Status {
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("%d wrong metrics", len(errs)))
+	br := &errdetails.BadRequest{}
+	for _, e := range errs {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       fmt.Sprintf("metrics[%d]", e.Index),
+			Description: e.Error,
+		})
+	}
+	if withDetails, err := st.WithDetails(br); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// grpcCode the gRPC code of the http status
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusOK:
+		return codes.OK
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	default:
+		return codes.Internal
+	}
+}
+
+// loggingUnary interceptor like the logging middleware
+func (s *Server) loggingUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	log.Println(info.FullMethod, req)
+	return handler(ctx, req)
+}
+
+// loggingStream interceptor like the logging middleware
+func (s *Server) loggingStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	log.Println(info.FullMethod)
+	return handler(srv, ss)
+}
+
+// incoming the first value of the key of the incoming metadata, the key is case insensitive
+func incoming(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(key); len(v) != 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
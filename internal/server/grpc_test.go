@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	pb "github.com/S0me0neR0man/yayaops/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestGRPCClient serve the gRPC service of s in memory
+func newTestGRPCClient(t *testing.T, s *Server) pb.MetricsClient {
+	l := bufconn.Listen(1 << 20)
+	g := newGRPCServer(s)
+	go func() {
+		_ = g.Serve(l)
+	}()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		g.Stop()
+	})
+	return pb.NewMetricsClient(conn)
+}
+
+func TestGRPCService_Update(t *testing.T) {
+	gauge := common.NewGauge("Alloc", 2.5)
+	tests := []struct {
+		name string
+		m    *pb.Metric
+		want codes.Code
+	}{
+		{name: "gauge", m: pb.FromMetrics(gauge), want: codes.OK},
+		{name: "counter", m: pb.FromMetrics(common.NewCounter("PollCount", 3)), want: codes.OK},
+		{name: "without value", m: &pb.Metric{Id: "Alloc", Type: common.MTypeGauge}, want: codes.InvalidArgument},
+		{name: "unknown type", m: &pb.Metric{Id: "Alloc", Type: "histogram"}, want: codes.Unimplemented},
+		{name: "empty", m: nil, want: codes.InvalidArgument},
+	}
+	client := newTestGRPCClient(t, &Server{repo: common.NewMemRepository()})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Update(context.Background(), &pb.UpdateRequest{Metric: tt.m})
+			assert.Equal(t, tt.want, status.Code(err), err)
+		})
+	}
+}
+
+func TestGRPCService_Updates(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{repo: common.NewMemRepository()}
+	client := newTestGRPCClient(t, s)
+
+	_, err := client.Updates(ctx, &pb.UpdatesRequest{Metrics: []*pb.Metric{
+		pb.FromMetrics(common.NewCounter("PollCount", 3)),
+		{Id: "Alloc", Type: common.MTypeGauge},
+	}})
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	br, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Len(t, br.FieldViolations, 1)
+	assert.Equal(t, "metrics[1]", br.FieldViolations[0].Field)
+	// nothing is applied
+	assert.ErrorIs(t, s.repo.Get(ctx, &common.Metrics{ID: "PollCount", MType: common.MTypeCounter}), common.ErrNotFound)
+
+	_, err = client.Updates(ctx, &pb.UpdatesRequest{Metrics: []*pb.Metric{
+		pb.FromMetrics(common.NewCounter("PollCount", 3)),
+		pb.FromMetrics(common.NewCounter("PollCount", 4)),
+	}})
+	require.NoError(t, err)
+	m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+	require.NoError(t, s.repo.Get(ctx, &m))
+	assert.Equal(t, int64(7), *m.Delta)
+}
+
+func TestGRPCService_UpdateStream(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{repo: common.NewMemRepository()}
+	client := newTestGRPCClient(t, s)
+
+	stream, err := client.UpdateStream(ctx)
+	require.NoError(t, err)
+	for _, m := range []common.Metrics{common.NewCounter("PollCount", 1), common.NewCounter("PollCount", 2)} {
+		require.NoError(t, stream.Send(&pb.UpdateRequest{Metric: pb.FromMetrics(m)}))
+	}
+	_, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+	m := common.Metrics{ID: "PollCount", MType: common.MTypeCounter}
+	require.NoError(t, s.repo.Get(ctx, &m))
+	assert.Equal(t, int64(3), *m.Delta)
+
+	stream, err = client.UpdateStream(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.UpdateRequest{Metric: &pb.Metric{Id: "Alloc", Type: common.MTypeGauge}}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCService_Value(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{cfg: Config{Key: "key", StaleAfter: time.Minute}, repo: common.NewMemRepository()}
+	old := time.Now().Add(-2 * time.Minute)
+	m := common.NewGauge("Alloc", 2.5)
+	m.Updated = &old
+	require.NoError(t, s.repo.Update(ctx, &m))
+	client := newTestGRPCClient(t, s)
+
+	resp, err := client.Value(ctx, &pb.ValueRequest{Metric: &pb.Metric{Id: "Alloc", Type: common.MTypeGauge}})
+	require.NoError(t, err)
+	got := resp.GetMetric().Metrics()
+	require.NotNil(t, got.Value)
+	assert.Equal(t, 2.5, *got.Value)
+	assert.True(t, got.CheckHash("key"))
+	assert.True(t, resp.GetStale())
+
+	_, err = client.Value(ctx, &pb.ValueRequest{Metric: &pb.Metric{Id: "Alloc", Type: common.MTypeCounter}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// gzipMinSize responses smaller than this are sent without compression
+const gzipMinSize = 1400
+
+// maxDecompressedSize the limit of the decompressed request body
+var maxDecompressedSize int64 = 10 << 20
+
+// gzipWriter compress the response if it is not smaller than gzipMinSize
+// the status is delayed until the size is known
+type gzipWriter struct {
+	http.ResponseWriter
+	status int
+	buf    []byte
+	gz     *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) < gzipMinSize {
+		return len(b), nil
+	}
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.statusCode())
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if _, err := w.gz.Write(w.buf); err != nil {
+		return 0, err
+	}
+	w.buf = nil
+	return len(b), nil
+}
+
+// close flush the compressed stream or send the small response as is
+func (w *gzipWriter) close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	w.ResponseWriter.WriteHeader(w.statusCode())
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
+}
+
+func (w *gzipWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// gzipping middleware
+// decompress the request with 'Content-Encoding: gzip', 413 if it exceeds maxDecompressedSize,
+// compress the response if the client sends 'Accept-Encoding: gzip'
+func (s *Server) gzipping(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+			b, err := decompress(r.Body)
+			if err != nil {
+				log.Println(err)
+				if errors.Is(err, errTooLarge) {
+					w.WriteHeader(http.StatusRequestEntityTooLarge)
+				} else {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(b))
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = int64(len(b))
+		}
+
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipWriter{ResponseWriter: w}
+		next.ServeHTTP(gw, r)
+		if err := gw.close(); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// errTooLarge the decompressed body exceeds maxDecompressedSize
+var errTooLarge = errors.New("decompressed body is too large")
+
+// decompress read the gzip body, the gzip bomb is stopped at maxDecompressedSize
+func decompress(body io.Reader) ([]byte, error) {
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(gz, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxDecompressedSize {
+		return nil, errTooLarge
+	}
+	return b, nil
+}
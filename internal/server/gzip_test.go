@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipping(t *testing.T) {
+	big := strings.Repeat("metric ", gzipMinSize)
+	tests := []struct {
+		name         string
+		response     string
+		acceptGzip   bool
+		wantEncoding string
+	}{
+		{name: "small response", response: "100", acceptGzip: true},
+		{name: "big response", response: big, acceptGzip: true, wantEncoding: "gzip"},
+		{name: "gzip not accepted", response: big},
+	}
+	s := &Server{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBody string
+			handler := s.gzipping(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				gotBody = string(b)
+				w.WriteHeader(http.StatusAccepted)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+
+			var body bytes.Buffer
+			gz := gzip.NewWriter(&body)
+			_, err := gz.Write([]byte("request"))
+			require.NoError(t, err)
+			require.NoError(t, gz.Close())
+
+			request := httptest.NewRequest(http.MethodPost, "/", &body)
+			request.Header.Set("Content-Encoding", "gzip")
+			if tt.acceptGzip {
+				request.Header.Set("Accept-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, "request", gotBody)
+			assert.Equal(t, http.StatusAccepted, result.StatusCode)
+			assert.Equal(t, tt.wantEncoding, result.Header.Get("Content-Encoding"))
+
+			var reader = result.Body
+			if tt.wantEncoding == "gzip" {
+				reader, err = gzip.NewReader(result.Body)
+				require.NoError(t, err)
+			}
+			b, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tt.response, string(b))
+		})
+	}
+}
+
+func TestGzipping_Limit(t *testing.T) {
+	saved := maxDecompressedSize
+	maxDecompressedSize = 1024
+	defer func() { maxDecompressedSize = saved }()
+
+	tests := []struct {
+		name     string
+		size     int
+		wantCode int
+	}{
+		{name: "at the limit", size: 1024, wantCode: http.StatusOK},
+		{name: "gzip bomb", size: 1 << 20, wantCode: http.StatusRequestEntityTooLarge},
+	}
+	s := &Server{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := s.gzipping(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.Len(t, b, tt.size)
+			}))
+
+			var body bytes.Buffer
+			gz := gzip.NewWriter(&body)
+			_, err := gz.Write(make([]byte, tt.size))
+			require.NoError(t, err)
+			require.NoError(t, gz.Close())
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", &body)
+			request.Header.Set("Content-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sample the value of the metric at the time, counters are totals
+type Sample struct {
+	T time.Time `json:"t"`
+	V float64   `json:"v"`
+}
+
+// seriesKey the metric of the history, a gauge and a counter with the same ID are different series
+type seriesKey struct {
+	mType string
+	common.Key
+}
+
+// maxHistorySamples the limit of samples per metric
+const maxHistorySamples = 100000
+
+// ring the bounded series of samples, the oldest sample is overwritten when it is full
+type ring struct {
+	samples  []Sample
+	capacity int
+	start    int // index of the oldest sample
+}
+
+func (r *ring) last() *Sample {
+	if len(r.samples) == 0 {
+		return nil
+	}
+	return &r.samples[(r.start+len(r.samples)-1)%len(r.samples)]
+}
+
+func (r *ring) push(s Sample) {
+	if len(r.samples) < r.capacity {
+		r.samples = append(r.samples, s)
+		return
+	}
+	r.samples[r.start] = s
+	r.start = (r.start + 1) % len(r.samples)
+}
+
+// dropBefore delete samples older than the time, samples are in time order
+func (r *ring) dropBefore(t time.Time) {
+	n := 0
+	for n < len(r.samples) && r.samples[(r.start+n)%len(r.samples)].T.Before(t) {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	kept := make([]Sample, 0, len(r.samples)-n)
+	for i := n; i < len(r.samples); i++ {
+		kept = append(kept, r.samples[(r.start+i)%len(r.samples)])
+	}
+	r.samples, r.start = kept, 0
+}
+
+// between samples in [from, to] in time order
+func (r *ring) between(from, to time.Time) []Sample {
+	list := make([]Sample, 0)
+	for i := 0; i < len(r.samples); i++ {
+		s := r.samples[(r.start+i)%len(r.samples)]
+		if !s.T.Before(from) && !s.T.After(to) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// history the in-memory history of metrics,
+// one sample per resolution interval is kept for the retention window,
+// older samples are dropped on add and by expire
+type history struct {
+	mu         sync.RWMutex
+	retention  time.Duration
+	resolution time.Duration
+	capacity   int
+	series     map[seriesKey]*ring
+}
+
+// newHistory the constructor, returns nil if retention is 0
+// the number of samples per metric is limited by maxHistorySamples,
+// every update is the sample if resolution is not positive
+func newHistory(retention, resolution time.Duration) *history {
+	if retention <= 0 {
+		return nil
+	}
+	if resolution <= 0 {
+		log.Printf("history resolution %v is not positive, every update is kept", resolution)
+		resolution = 0
+	}
+	capacity := maxHistorySamples
+	if resolution > 0 {
+		capacity = int(retention / resolution)
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	if capacity > maxHistorySamples || capacity < 0 {
+		log.Printf("history is limited by %d samples per metric", maxHistorySamples)
+		capacity = maxHistorySamples
+	}
+	return &history{
+		retention:  retention,
+		resolution: resolution,
+		capacity:   capacity,
+		series:     make(map[seriesKey]*ring),
+	}
+}
+
+// add stored values of metrics, the last sample of the same resolution interval is replaced
+func (h *history) add(list []common.Metrics, t time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, m := range list {
+		var v float64
+		switch {
+		case m.MType == common.MTypeGauge && m.Value != nil:
+			v = *m.Value
+		case m.MType == common.MTypeCounter && m.Delta != nil:
+			v = float64(*m.Delta)
+		default:
+			continue
+		}
+		k := seriesKey{mType: m.MType, Key: m.Key()}
+		r, ok := h.series[k]
+		if !ok {
+			r = &ring{capacity: h.capacity}
+			h.series[k] = r
+		}
+		if last := r.last(); last != nil && last.T.Truncate(h.resolution).Equal(t.Truncate(h.resolution)) {
+			*last = Sample{T: t, V: v}
+			continue
+		}
+		r.push(Sample{T: t, V: v})
+		r.dropBefore(t.Add(-h.retention))
+	}
+}
+
+// expire delete samples older than the time, series without samples are deleted
+func (h *history) expire(before time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for k, r := range h.series {
+		r.dropBefore(before)
+		if len(r.samples) == 0 {
+			delete(h.series, k)
+		}
+	}
+}
+
+// query samples of the metric in [from, to], false if the metric has not history
+func (h *history) query(mType string, k common.Key, from, to time.Time) ([]Sample, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	r, ok := h.series[seriesKey{mType: mType, Key: k}]
+	if !ok {
+		return nil, false
+	}
+	return r.between(from, to), true
+}
+
+// historyResponse the response of GET /history/
+type historyResponse struct {
+	ID      string   `json:"id"`
+	MType   string   `json:"type"`
+	Source  string   `json:"source,omitempty"`
+	Samples []Sample `json:"samples"`
+}
+
+// historyRequest the metric and the time range of history queries
+type historyRequest struct {
+	mType    string
+	key      common.Key
+	from, to time.Time
+}
+
+// parseHistoryRequest the request of /history/ and /query/ endpoints, returns the http status on error
+// from and to are RFC3339 or unix seconds, the retention window until now by default
+func (s *Server) parseHistoryRequest(r *http.Request) (historyRequest, int, error) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	req := historyRequest{
+		mType: vars[MuxMType],
+		key:   common.Key{Source: query.Get("source"), ID: vars[MuxMName]},
+	}
+	if s.history == nil {
+		return req, http.StatusNotFound, errors.New("history is disabled")
+	}
+	if req.mType != common.MTypeGauge && req.mType != common.MTypeCounter {
+		return req, http.StatusNotImplemented, fmt.Errorf("unknown metric type %q", req.mType)
+	}
+	req.to = time.Now()
+	req.from = req.to.Add(-s.history.retention)
+	var err error
+	if v := query.Get("from"); v != "" {
+		if req.from, err = parseTime(v); err != nil {
+			return req, http.StatusBadRequest, fmt.Errorf("from: %w", err)
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if req.to, err = parseTime(v); err != nil {
+			return req, http.StatusBadRequest, fmt.Errorf("to: %w", err)
+		}
+	}
+	return req, http.StatusOK, nil
+}
+
+// historyHandler GET /history/{type}/{metric}?from=&to=&source=
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	req, status, err := s.parseHistoryRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	samples, ok := s.history.query(req.mType, req.key, req.from, req.to)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, historyResponse{ID: req.key.ID, MType: req.mType, Source: req.key.Source, Samples: samples})
+}
+
+// writeJSON write v as the JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(b); err != nil {
+		log.Println(err)
+	}
+}
+
+// parseTime RFC3339 or unix seconds
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHistory_add(t *testing.T) {
+	h := newHistory(30*time.Second, 10*time.Second)
+	k := common.Key{ID: "Alloc"}
+	start := time.Unix(1000, 0)
+	for i, v := range []float64{1, 2, 3, 4, 5} {
+		h.add([]common.Metrics{common.NewGauge("Alloc", v)}, start.Add(time.Duration(i)*10*time.Second))
+	}
+	// the sample of the same resolution interval is replaced
+	h.add([]common.Metrics{common.NewGauge("Alloc", 6)}, start.Add(45*time.Second))
+
+	samples, ok := h.query(common.MTypeGauge, k, start, start.Add(time.Minute))
+	require.True(t, ok)
+	// the ring keeps 3 newest samples
+	assert.Equal(t, []Sample{
+		{T: start.Add(20 * time.Second), V: 3},
+		{T: start.Add(30 * time.Second), V: 4},
+		{T: start.Add(45 * time.Second), V: 6},
+	}, samples)
+
+	samples, _ = h.query(common.MTypeGauge, k, start.Add(25*time.Second), start.Add(40*time.Second))
+	assert.Equal(t, []Sample{{T: start.Add(30 * time.Second), V: 4}}, samples)
+
+	_, ok = h.query(common.MTypeCounter, k, start, start.Add(time.Minute))
+	assert.False(t, ok)
+}
+
+func TestHistory_retention(t *testing.T) {
+	h := newHistory(time.Minute, time.Second)
+	k := common.Key{ID: "Rare"}
+	start := time.Unix(1000, 0)
+	// the rare metric does not fill the ring, old samples are dropped by the time
+	for _, d := range []time.Duration{0, 50 * time.Second, 100 * time.Second} {
+		h.add([]common.Metrics{common.NewGauge("Rare", d.Seconds())}, start.Add(d))
+	}
+	samples, ok := h.query(common.MTypeGauge, k, start, start.Add(time.Hour))
+	require.True(t, ok)
+	assert.Equal(t, []Sample{{T: start.Add(50 * time.Second), V: 50}, {T: start.Add(100 * time.Second), V: 100}}, samples)
+
+	// expire trims the series, the empty series is deleted
+	h.expire(start.Add(60 * time.Second))
+	samples, ok = h.query(common.MTypeGauge, k, start, start.Add(time.Hour))
+	require.True(t, ok)
+	assert.Equal(t, []Sample{{T: start.Add(100 * time.Second), V: 100}}, samples)
+	h.expire(start.Add(time.Hour))
+	_, ok = h.query(common.MTypeGauge, k, start, start.Add(time.Hour))
+	assert.False(t, ok)
+}
+
+func TestNewHistory_zeroResolution(t *testing.T) {
+	h := newHistory(time.Hour, 0)
+	require.NotNil(t, h)
+	start := time.Unix(1000, 0)
+	h.add([]common.Metrics{common.NewGauge("Alloc", 1)}, start)
+	h.add([]common.Metrics{common.NewGauge("Alloc", 2)}, start.Add(time.Millisecond))
+	samples, ok := h.query(common.MTypeGauge, common.Key{ID: "Alloc"}, start, start.Add(time.Second))
+	require.True(t, ok)
+	assert.Len(t, samples, 2)
+}
+
+func TestHistoryHandler(t *testing.T) {
+	s := &Server{repo: common.NewMemRepository(), history: newHistory(time.Hour, time.Nanosecond)}
+	router := mux.NewRouter()
+	s.setHandlers(router)
+	for _, url := range []string{"/update/counter/PollCount/2", "/update/counter/PollCount/3", "/update/counter/PollCount/4?source=host-1"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		code int
+		want []float64
+	}{
+		{name: "all", url: "/history/counter/PollCount", code: http.StatusOK, want: []float64{2, 5}},
+		{name: "source", url: "/history/counter/PollCount?source=host-1", code: http.StatusOK, want: []float64{4}},
+		{name: "empty range", url: "/history/counter/PollCount?from=0&to=1", code: http.StatusOK, want: []float64{}},
+		{name: "unknown", url: "/history/gauge/PollCount", code: http.StatusNotFound},
+		{name: "wrong type", url: "/history/unknown/PollCount", code: http.StatusNotImplemented},
+		{name: "wrong time", url: "/history/counter/PollCount?from=yesterday", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			require.Equal(t, tt.code, w.Code)
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp historyResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			values := make([]float64, 0)
+			for _, sample := range resp.Samples {
+				values = append(values, sample.V)
+			}
+			assert.Equal(t, tt.want, values)
+		})
+	}
+
+	s.history = nil
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history/counter/PollCount", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
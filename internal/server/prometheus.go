@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prometheusContentType the text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promSample one line of the exposition
+type promSample struct {
+	name   string
+	mType  string
+	labels map[string]string
+	value  float64
+}
+
+// metricsHandler GET /metrics all metrics in the Prometheus text exposition format
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := s.repo.List(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	samples := make([]promSample, 0, len(list))
+	for _, m := range list {
+		if sample, ok := toPromSample(m); ok {
+			samples = append(samples, sample)
+		}
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	bw := bufio.NewWriter(w)
+	writePrometheus(bw, samples)
+	if err = bw.Flush(); err != nil {
+		log.Println(err)
+	}
+}
+
+// toPromSample convert the metric, the source is the label, counters get the _total suffix,
+// so a gauge and a counter with the same ID do not collide
+func toPromSample(m common.Metrics) (promSample, bool) {
+	sample := promSample{name: promName(m.ID), mType: m.MType}
+	if m.Source != "" {
+		sample.labels = map[string]string{"source": m.Source}
+	}
+	switch {
+	case m.MType == common.MTypeGauge && m.Value != nil:
+		sample.value = *m.Value
+	case m.MType == common.MTypeCounter && m.Delta != nil:
+		sample.name += "_total"
+		sample.value = float64(*m.Delta)
+	default:
+		return sample, false
+	}
+	return sample, true
+}
+
+// writePrometheus write samples grouped by name, every group starts with the TYPE line
+func writePrometheus(w *bufio.Writer, samples []promSample) {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].name < samples[j].name
+	})
+	for i, sample := range samples {
+		if i == 0 || samples[i-1].name != sample.name {
+			w.WriteString("# TYPE " + sample.name + " " + sample.mType + "\n")
+		}
+		w.WriteString(sample.name)
+		writePromLabels(w, sample.labels)
+		w.WriteByte(' ')
+		w.WriteString(promValue(sample.value))
+		w.WriteByte('\n')
+	}
+}
+
+// writePromLabels write {name="value",...} sorted by name, nothing if labels are empty
+func writePromLabels(w *bufio.Writer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.WriteByte('{')
+	for i, name := range names {
+		if i != 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(promName(name))
+		w.WriteString(`="`)
+		w.WriteString(promLabelEscaper.Replace(labels[name]))
+		w.WriteByte('"')
+	}
+	w.WriteByte('}')
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// promName replace characters not allowed in the metric name by '_'
+func promName(id string) string {
+	var b strings.Builder
+	for i, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// promValue format the sample value
+func promValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
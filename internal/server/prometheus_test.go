@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{repo: common.NewMemRepository()}
+	for _, m := range []common.Metrics{
+		common.NewGauge("HeapAlloc", 2.5),
+		common.NewGauge("PollCount", 1e21),
+		common.NewCounter("PollCount", 3),
+		common.NewGauge("cpu.1-util", 0.5),
+		{ID: "HeapAlloc", MType: common.MTypeGauge, Value: new(float64), Source: "host-1"},
+	} {
+		m := m
+		require.NoError(t, s.repo.Update(ctx, &m))
+	}
+
+	router := mux.NewRouter()
+	s.setHandlers(router)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE HeapAlloc gauge
+HeapAlloc 2.5
+HeapAlloc{source="host-1"} 0
+# TYPE PollCount gauge
+PollCount 1e+21
+# TYPE PollCount_total counter
+PollCount_total 3
+# TYPE cpu_1_util gauge
+cpu_1_util 0.5
+`, w.Body.String())
+}
+
+func Test_promName(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "Alloc", want: "Alloc"},
+		{id: "CPUutilization1", want: "CPUutilization1"},
+		{id: "1min", want: "_1min"},
+		{id: "disk.used%", want: "disk_used_"},
+		{id: "ns:name", want: "ns:name"},
+		{id: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			assert.Equal(t, tt.want, promName(tt.id))
+		})
+	}
+}
+
+func Test_writePromLabels(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writePromLabels(w, map[string]string{"source": `host "a"`, "agent-id": `c:\n`})
+	require.NoError(t, w.Flush())
+	assert.Equal(t, `{agent_id="c:\\n",source="host \"a\""}`, buf.String())
+}
@@ -1,14 +1,22 @@
 package server
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/S0me0neR0man/yayaops/internal/envelope"
+	"github.com/S0me0neR0man/yayaops/internal/pgrepo"
 	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"time"
 )
 
 const (
@@ -21,44 +29,176 @@ const (
 	MuxValue = "value"
 )
 
-type config struct {
-	addr string
+type Server struct {
+	cfg        Config
+	repo       common.Repository
+	store      *fileStore
+	history    *history // nil if disabled
+	alerts     *alerter // nil if disabled
+	sweeper    *sweeper // nil if nothing expires
+	httpServer *http.Server
+	grpcServer *grpc.Server    // nil if disabled
+	privateKey *rsa.PrivateKey // nil if requests are not encrypted
+	tlsConfig  *tls.Config     // nil if TLS is disabled
+	trusted    *net.IPNet      // nil if updates are allowed from everywhere
+	batches    batchIDs
 }
 
-var cfg config
-
-func init() {
-	if cfg.addr = os.Getenv("ADDRESS"); cfg.addr == "" {
-		cfg.addr = "127.0.0.1:8080"
+// New the constructor, the PostgreSQL repository is used if cfg.DatabaseDSN is set
+// otherwise metrics are kept in the memory and saved to the file
+func New(cfg Config) (*Server, error) {
+	log.Printf("Server config %v", cfg)
+	s := Server{cfg: cfg}
+	if cfg.CryptoKey != "" {
+		key, err := envelope.LoadPrivateKey(cfg.CryptoKey)
+		if err != nil {
+			return nil, err
+		}
+		s.privateKey = key
+	}
+	if cfg.TrustedSubnet != "" {
+		_, trusted, err := net.ParseCIDR(cfg.TrustedSubnet)
+		if err != nil {
+			return nil, err
+		}
+		s.trusted = trusted
+	}
+	if cfg.TLSCert != "" {
+		tlsConfig, err := common.ServerTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig = tlsConfig
+	}
+	s.history = newHistory(cfg.HistoryRetention, cfg.HistoryResolution)
+	if cfg.DatabaseDSN != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		repo, err := pgrepo.New(ctx, cfg.DatabaseDSN)
+		if err != nil {
+			return nil, err
+		}
+		s.repo = repo
+	} else {
+		s.repo = common.NewMemRepository()
+		if cfg.StoreFile != "" {
+			s.store = newFileStore(s.repo, cfg.StoreFile, cfg.StoreInterval)
+			if cfg.Restore {
+				if err := s.store.restore(); err != nil {
+					log.Println("restore:", err)
+				}
+			}
+		}
 	}
-	log.Printf("Server init %+v", cfg)
-}
 
-type Server struct {
-	storage *common.Storage
-}
+	if cfg.MetricTTL > 0 || s.history != nil {
+		s.sweeper = newSweeper(s.repo, s.history, cfg.MetricTTL)
+	}
+	if cfg.AlertRules != "" {
+		rules, err := loadRules(cfg.AlertRules)
+		if err != nil {
+			return nil, err
+		}
+		if s.alerts, err = newAlerter(rules, s.repo, s.history, cfg.AlertInterval, cfg.AlertWebhook); err != nil {
+			return nil, err
+		}
+	}
 
-func New() *Server {
-	s := Server{}
-	s.storage = common.NewStorage()
-	return &s
+	router := mux.NewRouter()
+	s.setHandlers(router)
+	s.httpServer = &http.Server{Addr: cfg.Addr, Handler: router, TLSConfig: s.tlsConfig}
+	if cfg.GRPCAddr != "" {
+		s.grpcServer = newGRPCServer(&s)
+	}
+	return &s, nil
 }
 
-// Start set handlers and start listening
+// Start listening, returns http.ErrServerClosed after Shutdown
+// gRPC requests are served on cfg.GRPCAddr in the background, both are served by TLS if it is configured
 func (s *Server) Start() error {
-	router := mux.NewRouter()
-	s.setHandlers(router)
+	if s.grpcServer != nil {
+		l, err := net.Listen("tcp", s.cfg.GRPCAddr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.grpcServer.Serve(l); err != nil {
+				log.Println("grpc:", err)
+			}
+		}()
+	}
+	if s.store != nil {
+		s.store.start()
+	}
+	if s.alerts != nil {
+		s.alerts.start()
+	}
+	if s.sweeper != nil {
+		s.sweeper.start()
+	}
+	if s.tlsConfig != nil {
+		// the certificate is in TLSConfig
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
+	return s.httpServer.ListenAndServe()
+}
 
-	return http.ListenAndServe(cfg.addr, router)
+// Shutdown wait for in-flight requests until ctx is done,
+// save the storage to the file last time and close the repository
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	if s.grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			s.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			s.grpcServer.Stop()
+		}
+	}
+	if s.sweeper != nil {
+		s.sweeper.stop()
+	}
+	if s.alerts != nil {
+		s.alerts.stop()
+	}
+	if s.store != nil {
+		if errStore := s.store.stop(); err == nil {
+			err = errStore
+		}
+	}
+	if errClose := s.repo.Close(); err == nil {
+		err = errClose
+	}
+	return err
 }
 
 // setHandlers configure gorilla/mux router
 func (s *Server) setHandlers(router *mux.Router) {
 	router.Use(s.logging)
+	router.Use(s.trustedSubnet)
+	router.Use(s.decrypting)
+	router.Use(s.gzipping)
 
+	router.HandleFunc("/", s.dashboardHandler).
+		Methods(http.MethodGet)
+	router.HandleFunc("/metrics", s.metricsHandler).
+		Methods(http.MethodGet)
+	router.HandleFunc("/history/{type}/{metric}", s.historyHandler).
+		Methods(http.MethodGet)
+	router.HandleFunc("/query/{type}/{metric}", s.queryHandler).
+		Methods(http.MethodGet)
+	router.HandleFunc("/alerts", s.alertsHandler).
+		Methods(http.MethodGet)
 	router.HandleFunc("/update/", s.updateJSONHandler).
 		Methods(http.MethodPost).
 		Headers("Content-Type", "application/json")
+	router.HandleFunc("/updates/", s.updatesJSONHandler).
+		Methods(http.MethodPost).
+		Headers("Content-Type", "application/json")
 	router.HandleFunc("/value/", s.valueJSONHandler).
 		Methods(http.MethodPost).
 		Headers("Content-Type", "application/json")
@@ -87,22 +227,27 @@ func (s *Server) notAcceptableHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // postHandler http.POST without 'Content-Type'
+// the hash and the source of the metric are passed in 'hash' and 'source' query parameters
 func (s *Server) postHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	if cmd, status := commandFromURL(vars); status == http.StatusOK {
-		s.executeCommand(cmd, w)
+		cmd.Hash = r.URL.Query().Get("hash")
+		cmd.Source = r.URL.Query().Get("source")
+		s.executeCommand(r.Context(), cmd, w)
 	} else {
 		w.WriteHeader(status)
 	}
 }
 
 // getHandler http.GET without 'Content-Type'
+// the source of the metric is passed in the 'source' query parameter
 func (s *Server) getHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	if cmd, status := commandFromURL(vars); status == http.StatusOK {
-		s.executeCommand(cmd, w)
+		cmd.Source = r.URL.Query().Get("source")
+		s.executeCommand(r.Context(), cmd, w)
 	} else {
 		w.WriteHeader(status)
 	}
@@ -114,11 +259,12 @@ func (s *Server) updateJSONHandler(w http.ResponseWriter, r *http.Request) {
 	var b []byte
 
 	if b, err = ioutil.ReadAll(r.Body); err == nil {
-		log.Println(string(b))
 		m := common.Metrics{}
 		if err = json.Unmarshal(b, &m); err == nil {
+			// the body may be decrypted, so values are not logged
+			log.Println("1 metric by HTTP")
 			cmd := common.Command{Metrics: m, CType: common.CTUpdate, JSONResp: true}
-			s.executeCommand(&cmd, w)
+			s.executeCommand(r.Context(), &cmd, w)
 			return
 		}
 	}
@@ -127,6 +273,57 @@ func (s *Server) updateJSONHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusBadRequest)
 }
 
+// batchError the error of one item of the updates/ request
+type batchError struct {
+	Index int    `json:"index"`
+	ID    string `json:"id"`
+	MType string `json:"type"`
+	Error string `json:"error"`
+}
+
+// updatesJSONHandler POST updates/
+// all metrics are applied or nothing, the response to the wrong batch lists errors of items
+// the batch with X-Batch-ID applied already is skipped, 503 if it is in progress
+func (s *Server) updatesJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var list []common.Metrics
+	b, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(b, &list)
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// the body may be decrypted, so values are not logged
+	log.Printf("%d metrics by HTTP", len(list))
+
+	errs, err := s.updateBatchOnce(r.Context(), r.Header.Get(batchIDHeader), list)
+	if errors.Is(err, errBatchInProgress) {
+		log.Println(err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(errs) != 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		if b, err = json.Marshal(errs); err == nil {
+			_, err = w.Write(b)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // valueJSONHandler POST value/
 func (s *Server) valueJSONHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
@@ -137,7 +334,7 @@ func (s *Server) valueJSONHandler(w http.ResponseWriter, r *http.Request) {
 		m := common.Metrics{}
 		if err = json.Unmarshal(b, &m); err == nil {
 			cmd := common.Command{Metrics: m, CType: common.CTValue, JSONResp: true}
-			s.executeCommand(&cmd, w)
+			s.executeCommand(r.Context(), &cmd, w)
 			return
 		}
 	}
@@ -146,60 +343,177 @@ func (s *Server) valueJSONHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusBadRequest)
 }
 
-func (s *Server) executeCommand(cmd *common.Command, w http.ResponseWriter) {
+func (s *Server) executeCommand(ctx context.Context, cmd *common.Command, w http.ResponseWriter) {
 	if cmd.JSONResp {
 		w.Header().Set("Content-Type", "application/json")
 	}
 	switch cmd.CType {
 	case common.CTUpdate:
-		switch cmd.MType {
-		case common.MTypeGauge:
-			if cmd.Value == nil {
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			_ = s.storage.Set(cmd.ID, *cmd.Value)
-		case common.MTypeCounter:
-			if cmd.Delta == nil {
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			if old, ok := s.storage.Get(cmd.ID); ok {
-				if err := s.storage.Set(cmd.ID, old, *cmd.Delta); err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
-			} else {
-				_ = s.storage.Set(cmd.ID, *cmd.Delta)
-			}
-		default:
-			w.WriteHeader(http.StatusNotImplemented)
+		if status, err := s.update(ctx, &cmd.Metrics); err != nil {
+			log.Println(err)
+			w.WriteHeader(status)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	case common.CTValue:
-		if v, ok := s.storage.Get(cmd.ID); ok {
-			var b []byte
-			var err error
-			if cmd.JSONResp {
-				if cmd.SetAnyValue(v) != nil {
-					w.WriteHeader(http.StatusNotFound)
-					return
-				}
-				b, err = json.Marshal(cmd)
-			} else {
-				b = []byte(fmt.Sprintf("%v", v))
-			}
-			if err == nil {
-				_, err = w.Write(b)
-			}
-			if err != nil {
-				log.Println(err)
-			}
-		} else {
-			w.WriteHeader(http.StatusNotFound)
+		if status, err := s.value(ctx, &cmd.Metrics); err != nil {
+			log.Println(cmd.ID, err)
+			w.WriteHeader(status)
 			return
 		}
+		var b []byte
+		var err error
+		if cmd.JSONResp {
+			cmd.Stale = s.stale(&cmd.Metrics, time.Now())
+			b, err = json.Marshal(cmd)
+		} else {
+			if cmd.Hash != "" {
+				w.Header().Set("Hash", cmd.Hash)
+			}
+			b = []byte(cmd.StrValue())
+		}
+		if err == nil {
+			_, err = w.Write(b)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// update validate and store the metric, m is filled by the stored value
+// returns http status and error, the transport of the request does not matter
+func (s *Server) update(ctx context.Context, m *common.Metrics) (int, error) {
+	status, err := s.checkUpdate(m)
+	if err == nil {
+		if err = s.repo.Update(ctx, m); err != nil {
+			status = statusOf(err)
+		}
+	}
+	if err != nil {
+		return status, err
+	}
+	s.afterUpdate([]common.Metrics{*m})
+	return http.StatusOK, nil
+}
+
+// updateBatch validate and store all metrics or nothing,
+// returns errors of wrong items, the error is the failure of the repository
+func (s *Server) updateBatch(ctx context.Context, list []common.Metrics) ([]batchError, error) {
+	var errs []batchError
+	for i := range list {
+		if _, err := s.checkUpdate(&list[i]); err != nil {
+			errs = append(errs, batchError{Index: i, ID: list[i].ID, MType: list[i].MType, Error: err.Error()})
+		}
+	}
+	if len(errs) != 0 {
+		return errs, nil
+	}
+	if err := s.repo.UpdateBatch(ctx, list); err != nil {
+		var itemErr *common.ItemError
+		if !errors.As(err, &itemErr) {
+			return nil, err
+		}
+		m := list[itemErr.Index]
+		return []batchError{{Index: itemErr.Index, ID: m.ID, MType: m.MType, Error: itemErr.Err.Error()}}, nil
+	}
+	s.afterUpdate(list)
+	return nil, nil
+}
+
+// value fill the metric by the stored value and the hash, returns http status and error
+// the metric without the source is the one of the empty source or of the only source
+func (s *Server) value(ctx context.Context, m *common.Metrics) (int, error) {
+	err := s.repo.Get(ctx, m)
+	if m.Source == "" && errors.Is(err, common.ErrNotFound) {
+		err = s.getOnlySource(ctx, m)
+	}
+	if err != nil {
+		if errors.Is(err, common.ErrTypeMismatch) {
+			return http.StatusNotFound, err
+		}
+		return statusOf(err), err
+	}
+	m.Hash = ""
+	if s.cfg.Key != "" {
+		if err := m.SetHash(s.cfg.Key); err != nil {
+			log.Println(err)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// getOnlySource fill m by the metric of the only source with the ID and the type,
+// returns ErrNotFound if there are no such metrics or there are several sources
+func (s *Server) getOnlySource(ctx context.Context, m *common.Metrics) error {
+	all, err := s.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	var found []common.Metrics
+	for _, x := range all {
+		if x.ID == m.ID && x.MType == m.MType {
+			found = append(found, x)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return common.ErrNotFound
+	case 1:
+		*m = found[0]
+		return nil
+	default:
+		return fmt.Errorf("%w: %s has %d sources, the source is required", common.ErrNotFound, m.ID, len(found))
+	}
+}
+
+// checkUpdate validate the metric before the update, returns http status and error
+// the update time of the client is dropped, it is set by the repository
+func (s *Server) checkUpdate(m *common.Metrics) (int, error) {
+	m.Updated = nil
+	switch m.MType {
+	case common.MTypeGauge:
+		if m.Value == nil {
+			return http.StatusBadRequest, fmt.Errorf("gauge %s without value", m.ID)
+		}
+	case common.MTypeCounter:
+		if m.Delta == nil {
+			return http.StatusBadRequest, fmt.Errorf("counter %s without delta", m.ID)
+		}
+	default:
+		return http.StatusNotImplemented, fmt.Errorf("%s: unknown metric type %q", m.ID, m.MType)
+	}
+	if s.cfg.Key != "" && !m.CheckHash(s.cfg.Key) {
+		return http.StatusBadRequest, fmt.Errorf("%s: wrong hash", m.ID)
+	}
+	return http.StatusOK, nil
+}
+
+// statusOf the http status of the repository error
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, common.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrTypeMismatch), errors.Is(err, common.ErrWrongMetric):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// stale true if the metric is not updated within cfg.StaleAfter
+func (s *Server) stale(m *common.Metrics, now time.Time) bool {
+	return s.cfg.StaleAfter > 0 && m.Updated != nil && now.Sub(*m.Updated) > s.cfg.StaleAfter
+}
+
+// afterUpdate record stored values of updated metrics to the history,
+// save the storage in synchronous mode
+func (s *Server) afterUpdate(list []common.Metrics) {
+	s.history.add(list, time.Now())
+	if s.store != nil && s.store.synchronous() {
+		if err := s.store.save(); err != nil {
+			log.Println(err)
+		}
 	}
 }
 
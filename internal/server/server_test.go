@@ -2,12 +2,15 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
+	"github.com/S0me0neR0man/yayaops/internal/common"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"testing"
 )
 
@@ -213,13 +216,13 @@ func TestHandlers(t *testing.T) {
 			},
 		},
 		{
-			name:        "#20 wrong JSON post ",
+			name:        "#20 JSON post counter with the gauge ID ",
 			url:         "/update/",
 			method:      http.MethodPost,
 			contentType: "application/json",
 			body:        "{\"id\":\"TOG\",\"type\":\"counter\",\"delta\":1000}",
 			want: want{
-				code:        http.StatusBadRequest,
+				code:        http.StatusOK,
 				contentType: "application/json",
 			},
 		},
@@ -245,9 +248,171 @@ func TestHandlers(t *testing.T) {
 				contentType: "application/json",
 			},
 		},
+		// ------ batch
+		{
+			name:        "#23 JSON batch post ",
+			url:         "/updates/",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        "[{\"id\":\"BC\",\"type\":\"counter\",\"delta\":10},{\"id\":\"BC\",\"type\":\"counter\",\"delta\":5},{\"id\":\"BG\",\"type\":\"gauge\",\"value\":1.5}]",
+			want: want{
+				code:        http.StatusOK,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:        "#24 JSON get after batch ",
+			url:         "/value/",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        "{\"id\":\"BC\",\"type\":\"counter\"}",
+			want: want{
+				code: http.StatusOK,
+				body: "{\"id\":\"BC\",\"type\":\"counter\",\"delta\":15}",
+			},
+		},
+		{
+			name:        "#25 wrong JSON batch post ",
+			url:         "/updates/",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        "[{\"id\":\"BC\",\"type\":\"counter\",\"delta\":10},{\"id\":\"BG\",\"type\":\"counter\"}]",
+			want: want{
+				code:        http.StatusBadRequest,
+				body:        "[{\"index\":1,\"id\":\"BG\",\"type\":\"counter\",\"error\":\"counter BG without delta\"}]",
+				contentType: "application/json",
+			},
+		},
+		{
+			name:        "#26 JSON get after wrong batch ",
+			url:         "/value/",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        "{\"id\":\"BC\",\"type\":\"counter\"}",
+			want: want{
+				code: http.StatusOK,
+				body: "{\"id\":\"BC\",\"type\":\"counter\",\"delta\":15}",
+			},
+		},
+		{
+			name:        "#27 invalid JSON batch post ",
+			url:         "/updates/",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        "{\"id\":\"BC\",\"type\":\"counter\",\"delta\":10}",
+			want: want{
+				code: http.StatusBadRequest,
+			},
+		},
+		{
+			name:        "#28 counter with the gauge ID ",
+			url:         "/update/counter/BG/7",
+			method:      http.MethodPost,
+			contentType: "text/plain",
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:        "#29 gauge with the counter ID ",
+			url:         "/value/gauge/BG",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			want: want{
+				code: http.StatusOK,
+				body: "1.5",
+			},
+		},
+		{
+			name:        "#30 counter with the gauge ID value ",
+			url:         "/value/counter/BG",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			want: want{
+				code: http.StatusOK,
+				body: "7",
+			},
+		},
+		// ------ sources
+		{
+			name:   "#31 set with the source ",
+			url:    "/update/gauge/SrcG/1.5?source=host-1",
+			method: http.MethodPost,
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:        "#32 JSON set with other source ",
+			url:         "/update/",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        "{\"id\":\"SrcG\",\"type\":\"gauge\",\"value\":2.5,\"source\":\"host-2\"}",
+			want: want{
+				code:        http.StatusOK,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "#33 get by the source ",
+			url:    "/value/gauge/SrcG?source=host-1",
+			method: http.MethodGet,
+			want: want{
+				code: http.StatusOK,
+				body: "1.5",
+			},
+		},
+		{
+			name:        "#34 JSON get by other source ",
+			url:         "/value/",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        "{\"id\":\"SrcG\",\"type\":\"gauge\",\"source\":\"host-2\"}",
+			want: want{
+				code: http.StatusOK,
+				body: "{\"id\":\"SrcG\",\"type\":\"gauge\",\"value\":2.5,\"source\":\"host-2\"}",
+			},
+		},
+		{
+			name:   "#35 get without the source of several sources ",
+			url:    "/value/gauge/SrcG",
+			method: http.MethodGet,
+			want: want{
+				code: http.StatusNotFound,
+			},
+		},
+		{
+			name:   "#36 set with the only source ",
+			url:    "/update/counter/SrcC/4?source=host-1",
+			method: http.MethodPost,
+			want: want{
+				code: http.StatusOK,
+			},
+		},
+		{
+			name:   "#37 get without the source of the only source ",
+			url:    "/value/counter/SrcC",
+			method: http.MethodGet,
+			want: want{
+				code: http.StatusOK,
+				body: "4",
+			},
+		},
+		{
+			name:        "#38 JSON get without the source of the only source ",
+			url:         "/value/",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        "{\"id\":\"SrcC\",\"type\":\"counter\"}",
+			want: want{
+				code: http.StatusOK,
+				body: "{\"id\":\"SrcC\",\"type\":\"counter\",\"delta\":4,\"source\":\"host-1\"}",
+			},
+		},
 		// {"id":"GCSys","type":"counter","delta":3807944}
 	}
-	s := New()
+	s, err := New(Config{})
+	require.NoError(t, err)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
@@ -277,8 +442,47 @@ func TestHandlers(t *testing.T) {
 			//require.NoError(t, err)
 
 			if tt.want.body != "" {
-				assert.Equal(t, tt.want.body, string(value))
+				// the update time is checked in TestValueStale
+				assert.Equal(t, tt.want.body, updatedRegexp.ReplaceAllString(string(value), ""))
 			}
 		})
 	}
 }
+
+var updatedRegexp = regexp.MustCompile(`,"updated":"[^"]*"`)
+
+func TestHandlersWithKey(t *testing.T) {
+	const key = "secret"
+	s, err := New(Config{Key: key})
+	require.NoError(t, err)
+	router := mux.NewRouter()
+	s.setHandlers(router)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	value := 5.5
+	m := common.Metrics{ID: "Signed", MType: common.MTypeGauge, Value: &value}
+	require.NoError(t, m.SetHash(key))
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/update/", string(b)).Code)
+
+	m.Hash = "0000"
+	b, err = json.Marshal(m)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/update/", string(b)).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/updates/", "["+string(b)+"]").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/update/gauge/Signed/1", "").Code)
+
+	w := serve(http.MethodPost, "/value/", "{\"id\":\"Signed\",\"type\":\"gauge\"}")
+	require.Equal(t, http.StatusOK, w.Code)
+	var got common.Metrics
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.True(t, got.CheckHash(key))
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	pb "github.com/S0me0neR0man/yayaops/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// realIPHeader the address of the agent, the gRPC metadata key is the lower case
+const realIPHeader = "X-Real-IP"
+
+// grpcUpdates methods of the gRPC service updating metrics
+var grpcUpdates = map[string]bool{
+	pb.Metrics_Update_FullMethodName:       true,
+	pb.Metrics_Updates_FullMethodName:      true,
+	pb.Metrics_UpdateStream_FullMethodName: true,
+}
+
+// isTrusted true if the trusted subnet is not set or contains the address
+func (s *Server) isTrusted(realIP string) bool {
+	if s.trusted == nil {
+		return true
+	}
+	ip := net.ParseIP(realIP)
+	return ip != nil && s.trusted.Contains(ip)
+}
+
+// isUpdate true for paths of POST /update/... and /updates/
+func isUpdate(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/"+OperUpdateMetric+"/") || r.URL.Path == "/updates/"
+}
+
+// trustedSubnet middleware
+// updates are forbidden if X-Real-IP is not in the trusted subnet, other requests are not checked
+func (s *Server) trustedSubnet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUpdate(r) && !s.isTrusted(r.Header.Get(realIPHeader)) {
+			log.Printf("update from untrusted %q", r.Header.Get(realIPHeader))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// realIP X-Real-IP of the gRPC metadata
+func realIP(ctx context.Context) string {
+	return incoming(ctx, realIPHeader)
+}
+
+// trustedUnary interceptor like the trustedSubnet middleware
+func (s *Server) trustedUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if grpcUpdates[info.FullMethod] && !s.isTrusted(realIP(ctx)) {
+		log.Printf("update from untrusted %q", realIP(ctx))
+		return nil, status.Error(codes.PermissionDenied, "untrusted address")
+	}
+	return handler(ctx, req)
+}
+
+// trustedStream interceptor like the trustedSubnet middleware
+func (s *Server) trustedStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if grpcUpdates[info.FullMethod] && !s.isTrusted(realIP(ss.Context())) {
+		log.Printf("update from untrusted %q", realIP(ss.Context()))
+		return status.Error(codes.PermissionDenied, "untrusted address")
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	pb "github.com/S0me0neR0man/yayaops/internal/proto"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+	tests := []struct {
+		name     string
+		trusted  *net.IPNet
+		method   string
+		url      string
+		body     string
+		realIP   string
+		wantCode int
+	}{
+		{name: "trusted update", trusted: trusted, method: http.MethodPost, url: "/update/gauge/Alloc/1", realIP: "192.168.1.10", wantCode: http.StatusOK},
+		{name: "untrusted update", trusted: trusted, method: http.MethodPost, url: "/update/gauge/Alloc/1", realIP: "10.0.0.1", wantCode: http.StatusForbidden},
+		{name: "update without ip", trusted: trusted, method: http.MethodPost, url: "/update/gauge/Alloc/1", wantCode: http.StatusForbidden},
+		{name: "wrong ip", trusted: trusted, method: http.MethodPost, url: "/update/gauge/Alloc/1", realIP: "192.168.1", wantCode: http.StatusForbidden},
+		{name: "untrusted json update", trusted: trusted, method: http.MethodPost, url: "/update/", body: `{"id":"Alloc","type":"gauge","value":1}`, realIP: "10.0.0.1", wantCode: http.StatusForbidden},
+		{name: "untrusted batch", trusted: trusted, method: http.MethodPost, url: "/updates/", body: `[{"id":"Alloc","type":"gauge","value":1}]`, realIP: "10.0.0.1", wantCode: http.StatusForbidden},
+		{name: "trusted batch", trusted: trusted, method: http.MethodPost, url: "/updates/", body: `[{"id":"Alloc","type":"gauge","value":1}]`, realIP: "192.168.1.10", wantCode: http.StatusOK},
+		{name: "untrusted value", trusted: trusted, method: http.MethodGet, url: "/value/gauge/Alloc", realIP: "10.0.0.1", wantCode: http.StatusOK},
+		{name: "untrusted json value", trusted: trusted, method: http.MethodPost, url: "/value/", body: `{"id":"Alloc","type":"gauge"}`, realIP: "10.0.0.1", wantCode: http.StatusOK},
+		{name: "untrusted dashboard", trusted: trusted, method: http.MethodGet, url: "/", wantCode: http.StatusOK},
+		{name: "without subnet", method: http.MethodPost, url: "/update/gauge/Alloc/1", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{repo: common.NewMemRepository(), trusted: tt.trusted}
+			m := common.NewGauge("Alloc", 1)
+			require.NoError(t, s.repo.Update(context.Background(), &m))
+			router := mux.NewRouter()
+			s.setHandlers(router)
+
+			r := httptest.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body))
+			if tt.body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			if tt.realIP != "" {
+				r.Header.Set(realIPHeader, tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestTrustedSubnet_GRPC(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+	s := &Server{repo: common.NewMemRepository(), trusted: trusted}
+	client := newTestGRPCClient(t, s)
+	gauge := pb.FromMetrics(common.NewGauge("Alloc", 1))
+
+	tests := []struct {
+		name   string
+		realIP string
+		want   codes.Code
+	}{
+		{name: "trusted", realIP: "192.168.1.10", want: codes.OK},
+		{name: "untrusted", realIP: "10.0.0.1", want: codes.PermissionDenied},
+		{name: "without ip", want: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.realIP != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, realIPHeader, tt.realIP)
+			}
+			_, err := client.Update(ctx, &pb.UpdateRequest{Metric: gauge})
+			assert.Equal(t, tt.want, status.Code(err), err)
+			_, err = client.Updates(ctx, &pb.UpdatesRequest{Metrics: []*pb.Metric{gauge}})
+			assert.Equal(t, tt.want, status.Code(err), err)
+
+			stream, err := client.UpdateStream(ctx)
+			require.NoError(t, err)
+			_ = stream.Send(&pb.UpdateRequest{Metric: gauge})
+			_, err = stream.CloseAndRecv()
+			assert.Equal(t, tt.want, status.Code(err), err)
+
+			// values are not checked
+			_, err = client.Value(ctx, &pb.ValueRequest{Metric: &pb.Metric{Id: "Alloc", Type: common.MTypeGauge}})
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	minSweepInterval = time.Second
+	maxSweepInterval = time.Minute
+)
+
+// sweeper deletes metrics not updated within ttl and the history older than the retention window
+type sweeper struct {
+	repo     common.Repository
+	history  *history
+	ttl      time.Duration // 0 if metrics do not expire
+	interval time.Duration
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newSweeper the constructor, the sweep interval is a tenth of ttl within [minSweepInterval, maxSweepInterval]
+func newSweeper(repo common.Repository, h *history, ttl time.Duration) *sweeper {
+	interval := ttl / 10
+	if interval < minSweepInterval {
+		interval = minSweepInterval
+	}
+	if interval > maxSweepInterval || ttl == 0 {
+		interval = maxSweepInterval
+	}
+	return &sweeper{
+		repo:     repo,
+		history:  h,
+		ttl:      ttl,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// start the sweep goroutine
+func (s *sweeper) start() {
+	s.wg.Add(1)
+	go s.sweepJob()
+}
+
+// stop the sweep goroutine
+func (s *sweeper) stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// sweepJob goroutine for periodic sweep
+func (s *sweeper) sweepJob() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+			if err := s.sweep(ctx, time.Now()); err != nil {
+				log.Println("sweep:", err)
+			}
+			cancel()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sweep delete expired metrics and samples of the history older than the retention window at now
+func (s *sweeper) sweep(ctx context.Context, now time.Time) error {
+	if s.history != nil {
+		s.history.expire(now.Add(-s.history.retention))
+	}
+	if s.ttl == 0 {
+		return nil
+	}
+	n, err := s.repo.Expire(ctx, now.Add(-s.ttl))
+	if n != 0 {
+		log.Printf("%d metrics not updated for %v are deleted", n, s.ttl)
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/S0me0neR0man/yayaops/internal/common"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValueStale(t *testing.T) {
+	ctx := context.Background()
+	s := &Server{cfg: Config{StaleAfter: time.Minute}, repo: common.NewMemRepository()}
+	old := time.Now().Add(-2 * time.Minute)
+	for _, m := range []common.Metrics{common.NewGauge("Old", 1), common.NewGauge("Fresh", 2)} {
+		m := m
+		if m.ID == "Old" {
+			m.Updated = &old
+		}
+		require.NoError(t, s.repo.Update(ctx, &m))
+	}
+	router := mux.NewRouter()
+	s.setHandlers(router)
+
+	for _, tt := range []struct {
+		id    string
+		stale bool
+	}{
+		{id: "Old", stale: true},
+		{id: "Fresh", stale: false},
+	} {
+		t.Run(tt.id, func(t *testing.T) {
+			body := `{"id":"` + tt.id + `","type":"gauge"}`
+			r := httptest.NewRequest(http.MethodPost, "/value/", bytes.NewBufferString(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var got common.Command
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, tt.stale, got.Stale)
+			require.NotNil(t, got.Updated)
+			if tt.stale {
+				assert.True(t, old.Equal(*got.Updated))
+			}
+		})
+	}
+
+	// the update time of the client is ignored
+	r := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewBufferString(`{"id":"Old","type":"gauge","value":3,"updated":"2000-01-01T00:00:00Z"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	m := common.Metrics{ID: "Old", MType: common.MTypeGauge}
+	require.NoError(t, s.repo.Get(ctx, &m))
+	assert.False(t, s.stale(&m, time.Now()))
+}
+
+func TestSweeper_sweep(t *testing.T) {
+	ctx := context.Background()
+	repo := common.NewMemRepository()
+	h := newHistory(time.Hour, time.Second)
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	for _, m := range []common.Metrics{common.NewGauge("Old", 1), common.NewCounter("Fresh", 2)} {
+		m := m
+		if m.ID == "Old" {
+			m.Updated = &old
+		}
+		require.NoError(t, repo.Update(ctx, &m))
+		h.add([]common.Metrics{m}, *m.Updated)
+	}
+
+	require.NoError(t, newSweeper(repo, h, time.Minute).sweep(ctx, now))
+	list, err := repo.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "Fresh", list[0].ID)
+	_, ok := h.query(common.MTypeGauge, common.Key{ID: "Old"}, old, now)
+	assert.False(t, ok, "the history of Old must expire")
+	_, ok = h.query(common.MTypeCounter, common.Key{ID: "Fresh"}, old, now)
+	assert.True(t, ok)
+
+	// without ttl only the history expires
+	m := common.NewGauge("Old", 1)
+	m.Updated = &old
+	require.NoError(t, repo.Update(ctx, &m))
+	require.NoError(t, newSweeper(repo, h, 0).sweep(ctx, now))
+	list, err = repo.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+}